@@ -0,0 +1,498 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"embed"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	attestationAPI = "https://api.github.com/repos/Homebrew/homebrew-core/attestations/sha256:%s"
+	// homebrewCoreRepo is the repository whose CI workflows publish bottles.
+	homebrewCoreRepo   = "https://github.com/Homebrew/homebrew-core"
+	trustedRootFile    = "trusted_root.json"
+	inTotoPayloadType  = "application/vnd.in-toto+json"
+	slsaProvenanceType = "https://slsa.dev/provenance/v1"
+	// githubActionsIssuer is the OIDC issuer of GitHub Actions workflow tokens.
+	githubActionsIssuer = "https://token.actions.githubusercontent.com"
+)
+
+// oidFulcioIssuer is the Fulcio certificate extension holding the OIDC issuer
+// of the signer's token, as a DER UTF8String.
+var oidFulcioIssuer = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+
+// embeddedRoot holds the trusted root built into the binary, see
+// sigstore/README.md.
+//
+//go:generate sh -c "gh attestation trusted-root > sigstore/.trusted_root.json.tmp && test -s sigstore/.trusted_root.json.tmp && mv sigstore/.trusted_root.json.tmp sigstore/trusted_root.json"
+//go:embed sigstore
+var embeddedRoot embed.FS
+
+// sigstoreBundle is a Sigstore bundle as returned by the GitHub attestations
+// API. Only the fields needed for offline verification are decoded.
+type sigstoreBundle struct {
+	MediaType            string `json:"mediaType"`
+	VerificationMaterial struct {
+		Certificate *struct {
+			RawBytes []byte `json:"rawBytes"`
+		} `json:"certificate"`
+		X509CertificateChain *struct {
+			Certificates []struct {
+				RawBytes []byte `json:"rawBytes"`
+			} `json:"certificates"`
+		} `json:"x509CertificateChain"`
+		TlogEntries []tlogEntry `json:"tlogEntries"`
+	} `json:"verificationMaterial"`
+	DSSEEnvelope *struct {
+		Payload     []byte          `json:"payload"`
+		PayloadType string          `json:"payloadType"`
+		Signatures  []dsseSignature `json:"signatures"`
+	} `json:"dsseEnvelope"`
+}
+
+type dsseSignature struct {
+	Sig   []byte `json:"sig"`
+	KeyID string `json:"keyid"`
+}
+
+// tlogEntry is a Rekor transparency log entry with its signed entry
+// timestamp (SET).
+type tlogEntry struct {
+	LogIndex string `json:"logIndex"`
+	LogID    struct {
+		KeyID []byte `json:"keyId"`
+	} `json:"logId"`
+	KindVersion struct {
+		Kind    string `json:"kind"`
+		Version string `json:"version"`
+	} `json:"kindVersion"`
+	IntegratedTime   string `json:"integratedTime"`
+	InclusionPromise *struct {
+		SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+	} `json:"inclusionPromise"`
+	CanonicalizedBody []byte `json:"canonicalizedBody"`
+}
+
+// trustedRoot is a Sigstore trusted root, e.g. from `gh attestation
+// trusted-root`.
+type trustedRoot struct {
+	Tlogs []struct {
+		PublicKey struct {
+			RawBytes []byte `json:"rawBytes"`
+		} `json:"publicKey"`
+		LogID struct {
+			KeyID []byte `json:"keyId"`
+		} `json:"logId"`
+	} `json:"tlogs"`
+	CertificateAuthorities []struct {
+		CertChain struct {
+			Certificates []struct {
+				RawBytes []byte `json:"rawBytes"`
+			} `json:"certificates"`
+		} `json:"certChain"`
+	} `json:"certificateAuthorities"`
+}
+
+// inTotoStatement is the DSSE payload of a build provenance attestation.
+type inTotoStatement struct {
+	Type    string `json:"_type"`
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string `json:"predicateType"`
+	Predicate     struct {
+		BuildDefinition struct {
+			ExternalParameters struct {
+				Workflow struct {
+					Ref        string `json:"ref"`
+					Repository string `json:"repository"`
+					Path       string `json:"path"`
+				} `json:"workflow"`
+			} `json:"externalParameters"`
+		} `json:"buildDefinition"`
+	} `json:"predicate"`
+}
+
+// loadTrustedRoot reads a Sigstore trusted root. The file may hold several
+// roots one after another (JSON lines); they are merged. An empty path means
+// $BOTTLE_BOMB_TRUSTED_ROOT, then trusted_root.json in the cache dir, then
+// the root built into the binary.
+func loadTrustedRoot(path string) (*trustedRoot, error) {
+	if path == "" {
+		path = os.Getenv("BOTTLE_BOMB_TRUSTED_ROOT")
+	}
+	cached := path == ""
+	if cached {
+		dir, err := cacheDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(dir, trustedRootFile)
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && cached {
+		if embedded, eerr := embeddedRoot.ReadFile("sigstore/" + trustedRootFile); eerr == nil {
+			path, data, err = "built-in "+trustedRootFile, embedded, nil
+		}
+	}
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no trusted root at %s: pass --trusted-root (e.g. saved from `gh attestation trusted-root`)", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read trusted root: %w", err)
+	}
+
+	root := &trustedRoot{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var r trustedRoot
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse trusted root %s: %w", path, err)
+		}
+		root.Tlogs = append(root.Tlogs, r.Tlogs...)
+		root.CertificateAuthorities = append(root.CertificateAuthorities, r.CertificateAuthorities...)
+	}
+	if len(root.CertificateAuthorities) == 0 || len(root.Tlogs) == 0 {
+		return nil, fmt.Errorf("trusted root %s has no certificate authorities or transparency logs", path)
+	}
+	return root, nil
+}
+
+// readAttestation reads a Sigstore bundle from path, or fetches the bundles
+// for digest from the GitHub attestations API when path is empty.
+func readAttestation(ctx context.Context, path, digest string) ([]*sigstoreBundle, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attestation: %w", err)
+		}
+		var bundle sigstoreBundle
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			return nil, fmt.Errorf("failed to parse attestation %s: %w", path, err)
+		}
+		return []*sigstoreBundle{&bundle}, nil
+	}
+
+	url := fmt.Sprintf(attestationAPI, digest)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	for _, env := range []string{"HOMEBREW_GITHUB_API_TOKEN", "GITHUB_TOKEN"} {
+		if token := os.Getenv(env); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+			break
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to http GET: %w", errNetwork, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: no attestation for sha256:%s", errVerify, digest)
+	default:
+		return nil, fmt.Errorf("%w: %s returned %s", errNetwork, url, resp.Status)
+	}
+
+	var body struct {
+		Attestations []struct {
+			Bundle *sigstoreBundle `json:"bundle"`
+		} `json:"attestations"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode attestations: %w", err)
+	}
+	var bundles []*sigstoreBundle
+	for _, a := range body.Attestations {
+		if a.Bundle != nil {
+			bundles = append(bundles, a.Bundle)
+		}
+	}
+	if len(bundles) == 0 {
+		return nil, fmt.Errorf("%w: no attestation for sha256:%s", errVerify, digest)
+	}
+	return bundles, nil
+}
+
+// verifyAttestation checks that bundle is a build provenance attestation for
+// digest, signed by a homebrew-core workflow with a certificate issued by
+// root and logged in one of its transparency logs. It returns the signing
+// workflow.
+func verifyAttestation(bundle *sigstoreBundle, root *trustedRoot, digest string) (string, error) {
+	env := bundle.DSSEEnvelope
+	if env == nil || len(env.Signatures) == 0 {
+		return "", errors.New("bundle has no DSSE envelope")
+	}
+	if env.PayloadType != inTotoPayloadType {
+		return "", fmt.Errorf("unexpected payload type '%s'", env.PayloadType)
+	}
+
+	// leaf certificate and any intermediates shipped in the bundle
+	var certs [][]byte
+	if c := bundle.VerificationMaterial.Certificate; c != nil {
+		certs = append(certs, c.RawBytes)
+	}
+	if chain := bundle.VerificationMaterial.X509CertificateChain; chain != nil {
+		for _, c := range chain.Certificates {
+			certs = append(certs, c.RawBytes)
+		}
+	}
+	if len(certs) == 0 {
+		return "", errors.New("bundle has no signing certificate")
+	}
+	leaf, err := x509.ParseCertificate(certs[0])
+	if err != nil {
+		return "", fmt.Errorf("failed to parse signing certificate: %w", err)
+	}
+	intermediates := x509.NewCertPool()
+	for _, der := range certs[1:] {
+		if c, err := x509.ParseCertificate(der); err == nil {
+			intermediates.AddCert(c)
+		}
+	}
+
+	// the short lived certificate is checked at the time the log saw it
+	signedAt, err := verifyTlog(bundle, root)
+	if err != nil {
+		return "", err
+	}
+
+	roots := x509.NewCertPool()
+	for _, ca := range root.CertificateAuthorities {
+		for _, c := range ca.CertChain.Certificates {
+			cert, err := x509.ParseCertificate(c.RawBytes)
+			if err != nil {
+				return "", fmt.Errorf("failed to parse trusted root certificate: %w", err)
+			}
+			if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+				roots.AddCert(cert)
+			} else {
+				intermediates.AddCert(cert)
+			}
+		}
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return "", fmt.Errorf("untrusted signing certificate: %w", err)
+	}
+
+	var signer string
+	if len(leaf.URIs) > 0 {
+		signer = leaf.URIs[0].String()
+	}
+	if !strings.HasPrefix(signer, homebrewCoreRepo+"/.github/workflows/") {
+		return "", fmt.Errorf("signed by '%s', not a %s workflow", signer, homebrewCoreRepo)
+	}
+	// the workflow URI only means something if GitHub Actions vouched for it
+	if issuer := fulcioIssuer(leaf); issuer != githubActionsIssuer {
+		return "", fmt.Errorf("signing certificate issued for '%s' tokens, not %s", issuer, githubActionsIssuer)
+	}
+
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("unsupported signing key %T", leaf.PublicKey)
+	}
+	pae := dssePAE(env.PayloadType, env.Payload)
+	if !slices.ContainsFunc(env.Signatures, func(s dsseSignature) bool {
+		return ecdsa.VerifyASN1(pub, ecdsaDigest(pub, pae), s.Sig)
+	}) {
+		return "", errors.New("invalid DSSE signature")
+	}
+
+	var statement inTotoStatement
+	if err := json.Unmarshal(env.Payload, &statement); err != nil {
+		return "", fmt.Errorf("failed to parse in-toto statement: %w", err)
+	}
+	if statement.PredicateType != slsaProvenanceType {
+		return "", fmt.Errorf("unexpected predicate type '%s'", statement.PredicateType)
+	}
+	matched := false
+	for _, subject := range statement.Subject {
+		matched = matched || strings.EqualFold(subject.Digest["sha256"], digest)
+	}
+	if !matched {
+		return "", fmt.Errorf("attestation subject doesn't match sha256:%s", digest)
+	}
+	workflow := statement.Predicate.BuildDefinition.ExternalParameters.Workflow
+	if workflow.Repository != homebrewCoreRepo || !strings.HasPrefix(workflow.Path, ".github/workflows/") {
+		return "", fmt.Errorf("built by '%s/%s', not a %s workflow", workflow.Repository, workflow.Path, homebrewCoreRepo)
+	}
+	return workflow.Repository + "/" + workflow.Path + "@" + workflow.Ref, nil
+}
+
+// fulcioIssuer returns the OIDC issuer recorded in a Fulcio certificate.
+func fulcioIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidFulcioIssuer) {
+			continue
+		}
+		var issuer string
+		if _, err := asn1.UnmarshalWithParams(ext.Value, &issuer, "utf8"); err == nil {
+			return issuer
+		}
+	}
+	return ""
+}
+
+// verifyTlog checks the signed entry timestamp of the bundle's transparency
+// log entry against the root's log keys and that the entry records this
+// envelope. It returns the time the entry was logged.
+func verifyTlog(bundle *sigstoreBundle, root *trustedRoot) (time.Time, error) {
+	for _, entry := range bundle.VerificationMaterial.TlogEntries {
+		if entry.InclusionPromise == nil {
+			continue
+		}
+		for _, log := range root.Tlogs {
+			if !bytes.Equal(log.LogID.KeyID, entry.LogID.KeyID) {
+				continue
+			}
+			key, err := x509.ParsePKIXPublicKey(log.PublicKey.RawBytes)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("failed to parse transparency log key: %w", err)
+			}
+			pub, ok := key.(*ecdsa.PublicKey)
+			if !ok {
+				return time.Time{}, fmt.Errorf("unsupported transparency log key %T", key)
+			}
+			integrated, err := strconv.ParseInt(entry.IntegratedTime, 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid integrated time: %w", err)
+			}
+			index, err := strconv.ParseInt(entry.LogIndex, 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid log index: %w", err)
+			}
+			// the SET signs the canonical JSON of these fields, keys sorted
+			set, err := json.Marshal(struct {
+				Body           []byte `json:"body"`
+				IntegratedTime int64  `json:"integratedTime"`
+				LogID          string `json:"logID"`
+				LogIndex       int64  `json:"logIndex"`
+			}{entry.CanonicalizedBody, integrated, hex.EncodeToString(entry.LogID.KeyID), index})
+			if err != nil {
+				return time.Time{}, err
+			}
+			if !ecdsa.VerifyASN1(pub, ecdsaDigest(pub, set), entry.InclusionPromise.SignedEntryTimestamp) {
+				return time.Time{}, errors.New("invalid signed entry timestamp")
+			}
+			if err := checkTlogBody(entry, bundle); err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(integrated, 0), nil
+		}
+	}
+	return time.Time{}, errors.New("no transparency log entry signed by a trusted log")
+}
+
+// checkTlogBody makes sure a dsse log entry is for the bundle's envelope.
+func checkTlogBody(entry tlogEntry, bundle *sigstoreBundle) error {
+	if entry.KindVersion.Kind != "dsse" {
+		return fmt.Errorf("unsupported transparency log entry kind '%s'", entry.KindVersion.Kind)
+	}
+	var body struct {
+		Spec struct {
+			PayloadHash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"payloadHash"`
+			Signatures []struct {
+				Signature []byte `json:"signature"`
+			} `json:"signatures"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(entry.CanonicalizedBody, &body); err != nil {
+		return fmt.Errorf("failed to parse transparency log entry: %w", err)
+	}
+	sum := sha256.Sum256(bundle.DSSEEnvelope.Payload)
+	if body.Spec.PayloadHash.Algorithm != "sha256" || body.Spec.PayloadHash.Value != hex.EncodeToString(sum[:]) {
+		return errors.New("transparency log entry is for a different payload")
+	}
+	for _, logged := range body.Spec.Signatures {
+		for _, s := range bundle.DSSEEnvelope.Signatures {
+			if bytes.Equal(logged.Signature, s.Sig) {
+				return nil
+			}
+		}
+	}
+	return errors.New("transparency log entry is for a different signature")
+}
+
+// dssePAE is the DSSE pre-authentication encoding that is actually signed.
+func dssePAE(payloadType string, payload []byte) []byte {
+	pae := fmt.Sprintf("DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	return append([]byte(pae), payload...)
+}
+
+// ecdsaDigest hashes msg with the hash matching the key's curve.
+func ecdsaDigest(pub *ecdsa.PublicKey, msg []byte) []byte {
+	switch pub.Curve {
+	case elliptic.P384():
+		sum := sha512.Sum384(msg)
+		return sum[:]
+	case elliptic.P521():
+		sum := sha512.Sum512(msg)
+		return sum[:]
+	}
+	sum := sha256.Sum256(msg)
+	return sum[:]
+}
+
+// checkAttestation verifies the build provenance of a downloaded bottle and
+// deletes it if every attestation is rejected, so it isn't picked up from the
+// cache later. Failing to get a trusted root or the attestations leaves the
+// bottle alone. bundlePath reads the attestation from disk instead of GitHub.
+func checkAttestation(ctx context.Context, res *downloadResult, bundlePath, rootPath string) error {
+	root, err := loadTrustedRoot(rootPath)
+	if err != nil {
+		return err
+	}
+	bundles, err := readAttestation(ctx, bundlePath, res.Sha256)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, bundle := range bundles {
+		workflow, err := verifyAttestation(bundle, root, res.Sha256)
+		if err == nil {
+			logger.Info("Verified attestation", "formula", res.Formula, "workflow", workflow)
+			res.Attested = true
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	if err := os.Remove(res.Path); err != nil && !os.IsNotExist(err) {
+		logger.Warn("Failed to remove unverified bottle", "path", res.Path, "err", err)
+	} else {
+		logger.Warn("Removed unverified bottle", "path", res.Path)
+	}
+	return fmt.Errorf("%w: %w", errVerify, errors.Join(errs...))
+}
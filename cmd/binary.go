@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"encoding/binary"
+	"slices"
+	"strings"
+)
+
+// binaryInfo describes a Mach-O or ELF file found in a bottle.
+type binaryInfo struct {
+	Format    string   `json:"format"`
+	Arch      []string `json:"arch"`
+	Libraries []string `json:"libraries,omitempty"`
+	RPaths    []string `json:"rpaths,omitempty"`
+	Glibc     string   `json:"glibc,omitempty"` // newest GLIBC_ symbol version needed
+}
+
+// isBinary reports whether the file starts with a Mach-O, universal or ELF
+// magic number.
+func isBinary(magic []byte) bool {
+	if len(magic) < 4 {
+		return false
+	}
+	if bytes.Equal(magic[:4], []byte(elf.ELFMAG)) {
+		return true
+	}
+	switch binary.BigEndian.Uint32(magic) {
+	case macho.Magic32, macho.Magic64, macho.MagicFat:
+		return true
+	}
+	switch binary.LittleEndian.Uint32(magic) {
+	case macho.Magic32, macho.Magic64:
+		return true
+	}
+	return false
+}
+
+// parseBinary reads the architectures, linked libraries and rpaths of a
+// Mach-O, universal or ELF file. It returns nil for anything else.
+func parseBinary(data []byte) *binaryInfo {
+	r := bytes.NewReader(data)
+	if f, err := macho.NewFile(r); err == nil {
+		defer f.Close()
+		info := &binaryInfo{Format: "macho"}
+		info.addMachO(f)
+		return info.sorted()
+	}
+	if ff, err := macho.NewFatFile(r); err == nil {
+		defer ff.Close()
+		info := &binaryInfo{Format: "macho"}
+		for _, a := range ff.Arches {
+			info.addMachO(a.File)
+		}
+		return info.sorted()
+	}
+	if f, err := elf.NewFile(r); err == nil {
+		defer f.Close()
+		info := &binaryInfo{Format: "elf", Arch: []string{elfArch(f.Machine)}}
+		info.Libraries, _ = f.ImportedLibraries()
+		for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+			paths, _ := f.DynString(tag)
+			for _, p := range paths {
+				info.RPaths = append(info.RPaths, strings.Split(p, ":")...)
+			}
+		}
+		syms, _ := f.ImportedSymbols()
+		for _, sym := range syms {
+			if v, ok := strings.CutPrefix(sym.Version, "GLIBC_"); ok && compareVersions(v, info.Glibc) > 0 {
+				info.Glibc = v
+			}
+		}
+		return info.sorted()
+	}
+	return nil
+}
+
+func (info *binaryInfo) addMachO(f *macho.File) {
+	info.Arch = append(info.Arch, machoArch(f.Cpu))
+	libs, _ := f.ImportedLibraries()
+	info.Libraries = append(info.Libraries, libs...)
+	for _, l := range f.Loads {
+		if rpath, ok := l.(*macho.Rpath); ok {
+			info.RPaths = append(info.RPaths, rpath.Path)
+		}
+	}
+}
+
+func (info *binaryInfo) sorted() *binaryInfo {
+	info.Libraries = sortedUnique(info.Libraries)
+	info.RPaths = sortedUnique(info.RPaths)
+	return info
+}
+
+func machoArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.CpuAmd64:
+		return "x86_64"
+	}
+	return cpu.String()
+}
+
+func elfArch(m elf.Machine) string {
+	switch m {
+	case elf.EM_AARCH64:
+		return "arm64"
+	case elf.EM_X86_64:
+		return "x86_64"
+	}
+	return m.String()
+}
+
+func sortedUnique(s []string) []string {
+	slices.Sort(s)
+	return slices.Compact(s)
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// BottleFile is a single platform specific bottle of a formula.
+type BottleFile struct {
+	Tag    string
+	Label  string
+	Cellar string
+	URL    string
+	Sha256 string
+}
+
+// Bottles returns the stable bottles of the formula in display order.
+func (f *Formula) Bottles() []BottleFile {
+	var bottles []BottleFile
+	for _, b := range f.allBottles() {
+		if b.URL != "" {
+			bottles = append(bottles, b)
+		}
+	}
+	return bottles
+}
+
+// allBottles lists every bottle tag the formula could have, with its file if
+// it has one.
+func (f *Formula) allBottles() []BottleFile {
+	files := f.Bottle.Stable.Files
+	return []BottleFile{
+		{"arm64_tahoe", "macOS Tahoe (arm64)", files.Arm64Tahoe.Cellar, files.Arm64Tahoe.URL, files.Arm64Tahoe.Sha256},
+		{"arm64_sequoia", "macOS Sequoia (arm64)", files.Arm64Sequoia.Cellar, files.Arm64Sequoia.URL, files.Arm64Sequoia.Sha256},
+		{"arm64_sonoma", "macOS Sonoma (arm64)", files.Arm64Sonoma.Cellar, files.Arm64Sonoma.URL, files.Arm64Sonoma.Sha256},
+		{"arm64_ventura", "macOS Ventura (arm64)", files.Arm64Ventura.Cellar, files.Arm64Ventura.URL, files.Arm64Ventura.Sha256},
+		{"arm64_monterey", "macOS Monterey (arm64)", files.Arm64Monterey.Cellar, files.Arm64Monterey.URL, files.Arm64Monterey.Sha256},
+		{"tahoe", "macOS Tahoe (x86_64)", files.Tahoe.Cellar, files.Tahoe.URL, files.Tahoe.Sha256},
+		{"sequoia", "macOS Sequoia (x86_64)", files.Sequoia.Cellar, files.Sequoia.URL, files.Sequoia.Sha256},
+		{"sonoma", "macOS Sonoma (x86_64)", files.Sonoma.Cellar, files.Sonoma.URL, files.Sonoma.Sha256},
+		{"ventura", "macOS Ventura (x86_64)", files.Ventura.Cellar, files.Ventura.URL, files.Ventura.Sha256},
+		{"monterey", "macOS Monterey (x86_64)", files.Monterey.Cellar, files.Monterey.URL, files.Monterey.Sha256},
+		{"arm64_linux", "Linux (arm64)", files.Arm64Linux.Cellar, files.Arm64Linux.URL, files.Arm64Linux.Sha256},
+		{"x86_64_linux", "Linux (x86_64)", files.X8664Linux.Cellar, files.X8664Linux.URL, files.X8664Linux.Sha256},
+	}
+}
+
+// knownBottleTag reports whether tag is one Bottles can return.
+func knownBottleTag(tag string) bool {
+	return slices.ContainsFunc((&Formula{}).allBottles(), func(b BottleFile) bool { return b.Tag == tag })
+}
+
+// BottleFor returns the bottle for the given tag, or the best match for the
+// running host when tag is empty.
+func (f *Formula) BottleFor(tag string) (*BottleFile, error) {
+	bottles := f.Bottles()
+	if tag == "" {
+		for _, b := range bottles {
+			if hostMatches(b.Tag) {
+				return &b, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: '%s' has no bottle for %s/%s", errNoBottle, f.Name, runtime.GOOS, runtime.GOARCH)
+	}
+	for _, b := range bottles {
+		if b.Tag == tag {
+			return &b, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: '%s' has no bottle for tag '%s'", errNoBottle, f.Name, tag)
+}
+
+// hostMatches reports whether a bottle tag can run on the current host.
+func hostMatches(tag string) bool {
+	linux := strings.HasSuffix(tag, "_linux")
+	arm := strings.HasPrefix(tag, "arm64_")
+	switch runtime.GOOS {
+	case "linux":
+		return linux && arm == (runtime.GOARCH == "arm64")
+	case "darwin":
+		return !linux && arm == (runtime.GOARCH == "arm64")
+	}
+	return false
+}
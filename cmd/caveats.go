@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+var errDisabled = errors.New("formula is disabled")
+
+// deprecateDisableReasons are the reason symbols the formulae API uses, as
+// worded by Homebrew.
+var deprecateDisableReasons = map[string]string{
+	"does_not_build":      "does not build",
+	"no_license":          "has no license",
+	"repo_archived":       "has an archived upstream repository",
+	"repo_removed":        "has a removed upstream repository",
+	"unmaintained":        "is not maintained upstream",
+	"unsupported":         "is not supported upstream",
+	"deprecated_upstream": "is deprecated upstream",
+	"versioned_formula":   "is a versioned formula",
+	"checksum_mismatch":   "was built from a source file whose checksum has since changed upstream",
+}
+
+// reasonText words a deprecate/disable reason to follow "because it".
+func reasonText(reason string) string {
+	if text, ok := deprecateDisableReasons[reason]; ok {
+		return text
+	}
+	return reason
+}
+
+// deprecationNotice describes why and since when the formula is deprecated,
+// or returns "" if it isn't.
+func (f *Formula) deprecationNotice() string {
+	if !f.Deprecated {
+		return ""
+	}
+	notice := fmt.Sprintf("'%s' has been deprecated", f.Name)
+	if date := f.DeprecationDate; date != "" {
+		notice += " since " + date
+	}
+	if reason := f.DeprecationReason; reason != "" {
+		notice += " because it " + reasonText(reason)
+	}
+	return notice
+}
+
+// checkDisabled fails for formulae Homebrew has disabled.
+func (f *Formula) checkDisabled() error {
+	if !f.Disabled {
+		return nil
+	}
+	msg := fmt.Sprintf("'%s' has been disabled", f.Name)
+	if date := f.DisableDate; date != "" {
+		msg += " since " + date
+	}
+	if reason := f.DisableReason; reason != "" {
+		msg += " because it " + reasonText(reason)
+	}
+	return fmt.Errorf("%w: %s (use --allow-disabled to download it anyway)", errDisabled, msg)
+}
+
+// caveats returns the formula's caveats with $HOMEBREW_PREFIX filled in when
+// installing into a prefix.
+func (f *Formula) caveats(prefix string) string {
+	caveats := strings.TrimSpace(f.Caveats)
+	if prefix != "" {
+		caveats = strings.ReplaceAll(caveats, "$HOMEBREW_PREFIX", prefix)
+	}
+	return caveats
+}
+
+// printCaveats writes the caveats to stderr, like `brew` does after install.
+func printCaveats(caveats string) {
+	if caveats == "" {
+		return
+	}
+	s := NewStyles(lipgloss.DefaultRenderer())
+	fmt.Fprintln(os.Stderr, s.StatusHeader.Render("==> Caveats"))
+	fmt.Fprintln(os.Stderr, caveats)
+}
@@ -0,0 +1,17 @@
+package cmd
+
+import "testing"
+
+func TestDeprecationNotice(t *testing.T) {
+	// the API sends the reason symbol without its leading colon
+	f := &Formula{Name: "tool", Deprecated: true, DeprecationDate: "2024-09-01", DeprecationReason: "unmaintained"}
+	want := "'tool' has been deprecated since 2024-09-01 because it is not maintained upstream"
+	if got := f.deprecationNotice(); got != want {
+		t.Errorf("deprecationNotice() = %q, want %q", got, want)
+	}
+
+	f = &Formula{Name: "tool", Disabled: true, DisableReason: "a custom reason"}
+	if err := f.checkDisabled(); err == nil || exitCode(err) != exitDisabled {
+		t.Errorf("checkDisabled() = %v, want exit code %d", err, exitDisabled)
+	}
+}
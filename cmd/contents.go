@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+// bottleEntry is a file inside a bottle as listed by `contents`.
+type bottleEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	Mode string `json:"mode"`
+	Link string `json:"link,omitempty"`
+
+	exec bool // any of the execute bits is set
+}
+
+// checksumReader hashes what is read through it and fails at EOF if the
+// stream doesn't match the expected sha256.
+type checksumReader struct {
+	io.ReadCloser
+	hash   hash.Hash
+	sha256 string
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && r.sha256 != "" && hex.EncodeToString(r.hash.Sum(nil)) != r.sha256 {
+		return n, fmt.Errorf("%w: expected %s", errChecksum, r.sha256)
+	}
+	return n, err
+}
+
+// openBottleSource opens a bottle tarball given either as a local file or as
+// a formula name. A formula's bottle is read from the download cache when
+// present and streamed from the network otherwise.
+func openBottleSource(ctx context.Context, arg, tag string) (io.ReadCloser, error) {
+	if fi, err := os.Stat(arg); err == nil && !fi.IsDir() {
+		f, err := os.Open(arg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open bottle: %w", err)
+		}
+		return f, nil
+	}
+
+	formula, err := getFormula(ctx, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get formula '%s': %w", arg, err)
+	}
+	bottle, err := formula.BottleFor(tag)
+	if err != nil {
+		return nil, err
+	}
+	return openFormulaBottle(ctx, formula, bottle)
+}
+
+// openFormulaBottle reads bottle from the download cache when present and
+// streams it from the network otherwise.
+func openFormulaBottle(ctx context.Context, formula *Formula, bottle *BottleFile) (io.ReadCloser, error) {
+	if dst, err := downloadPath(formula, bottle, true); err == nil {
+		if res := cachedBottle(formula, bottle, dst); res != nil {
+			return os.Open(res.Path)
+		}
+	}
+	resp, err := openBottle(ctx, bottle)
+	if err != nil {
+		return nil, err
+	}
+	return &checksumReader{ReadCloser: resp.Body, hash: sha256.New(), sha256: bottle.Sha256}, nil
+}
+
+// listBottle reads the entries of a gzipped bottle tarball.
+func listBottle(r io.Reader) ([]bottleEntry, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip: %w", err)
+	}
+	defer gz.Close()
+
+	var entries []bottleEntry
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar: %w", err)
+		}
+		e := bottleEntry{
+			Path: hdr.Name,
+			Size: hdr.Size,
+			Mode: fmt.Sprintf("%04o", hdr.Mode&0o7777),
+			Link: hdr.Linkname,
+			exec: hdr.Mode&0o111 != 0,
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			e.Type = "dir"
+		case tar.TypeSymlink:
+			e.Type = "symlink"
+		case tar.TypeLink:
+			e.Type = "hardlink"
+		case tar.TypeReg:
+			e.Type = "file"
+		default:
+			e.Type = string(hdr.Typeflag)
+		}
+		entries = append(entries, e)
+	}
+	// drain the gzip stream so a checksumReader sees EOF
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return nil, fmt.Errorf("failed to read gzip: %w", err)
+	}
+	return entries, nil
+}
+
+// kegPath returns a bottle entry's path inside its <name>/<version> keg.
+func kegPath(name string) string {
+	parts := strings.SplitN(strings.TrimPrefix(name, "./"), "/", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[2]
+}
+
+// isCommand reports whether the entry is an executable: a file with an
+// execute bit, or a symlink brew links into bin or sbin (a symlink's own mode
+// says nothing about its target).
+func (e bottleEntry) isCommand() bool {
+	switch e.Type {
+	case "file", "hardlink":
+		return e.exec
+	case "symlink":
+		rel := kegPath(e.Path)
+		return strings.HasPrefix(rel, "bin/") || strings.HasPrefix(rel, "sbin/")
+	}
+	return false
+}
+
+// contentsCmd represents the contents command
+var contentsCmd = &cobra.Command{
+	Use:           "contents <formula|bottle.tar.gz>",
+	Short:         "List the files in a bottle without extracting it",
+	Args:          cobra.ExactArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tag, _ := cmd.Flags().GetString("tag")
+		bin, _ := cmd.Flags().GetBool("bin")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+
+		r, err := openBottleSource(ctx, args[0], tag)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		entries, err := listBottle(r)
+		if err != nil {
+			return err
+		}
+
+		var shown []bottleEntry
+		for _, e := range entries {
+			if !bin || e.isCommand() {
+				shown = append(shown, e)
+			}
+		}
+		if asJSON {
+			if shown == nil {
+				shown = []bottleEntry{}
+			}
+			return printJSON(shown)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, e := range shown {
+			path := e.Path
+			switch e.Type {
+			case "symlink":
+				path += " -> " + e.Link
+			case "hardlink":
+				path += " => " + e.Link
+			}
+			size := ""
+			if e.Type == "file" {
+				size = humanize.Bytes(uint64(e.Size))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", e.Mode, size, path)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(contentsCmd)
+	contentsCmd.Flags().StringP("tag", "t", "", "Bottle tag to list (defaults to the host)")
+	contentsCmd.Flags().Bool("bin", false, "Only show executables and the links in bin and sbin")
+	contentsCmd.Flags().Bool("json", false, "Print the entries as JSON")
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestContentsCommands(t *testing.T) {
+	data := testBottle(t,
+		tar.Header{Name: "tool/1.0/bin/", Typeflag: tar.TypeDir, Mode: 0o755},
+		tar.Header{Name: "tool/1.0/bin/tool", Mode: 0o755},
+		tar.Header{Name: "tool/1.0/bin/tool-completion.txt"},
+		tar.Header{Name: "tool/1.0/bin/t", Linkname: "tool", Mode: 0o777},
+		tar.Header{Name: "tool/1.0/libexec/helper", Mode: 0o555},
+		tar.Header{Name: "tool/1.0/libexec/helper2", Linkname: "=tool/1.0/libexec/helper", Mode: 0o555},
+		tar.Header{Name: "tool/1.0/lib/libtool.so", Linkname: "libtool.so.1", Mode: 0o777},
+		tar.Header{Name: "tool/1.0/share/README"},
+	)
+	entries, err := listBottle(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		if e.isCommand() {
+			got = append(got, e.Path)
+		}
+	}
+	want := []string{"tool/1.0/bin/tool", "tool/1.0/bin/t", "tool/1.0/libexec/helper", "tool/1.0/libexec/helper2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("commands %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// installDB is the record of kegs bottle-bomb installed into a prefix, kept in
+// <prefix>/var/bottle-bomb/installed.json.
+type installDB struct {
+	path   string
+	prefix string
+	Kegs   []*installRecord `json:"kegs"`
+}
+
+// installRecord is everything bottle-bomb wrote for a single keg. Paths are
+// relative to the prefix.
+type installRecord struct {
+	Name               string    `json:"name"`
+	Version            string    `json:"version"`
+	Tag                string    `json:"tag"`
+	Rebuild            int       `json:"rebuild"`
+	Files              []string  `json:"files"`
+	Links              []string  `json:"links"`
+	Dependencies       []string  `json:"dependencies"`
+	InstalledOnRequest bool      `json:"installed_on_request"`
+	InstalledAt        time.Time `json:"installed_at"`
+}
+
+func (r *installRecord) keg(prefix string) *Keg {
+	return &Keg{Prefix: prefix, Name: r.Name, Version: r.Version}
+}
+
+func openInstallDB(prefix string) (*installDB, error) {
+	db := &installDB{
+		path:   filepath.Join(prefix, "var", "bottle-bomb", "installed.json"),
+		prefix: prefix,
+	}
+	data, err := os.ReadFile(db.path)
+	if os.IsNotExist(err) {
+		return db, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read install database: %w", err)
+	}
+	if err := json.Unmarshal(data, db); err != nil {
+		return nil, fmt.Errorf("failed to parse install database %s: %w", db.path, err)
+	}
+	return db, nil
+}
+
+func (db *installDB) save() error {
+	if err := os.MkdirAll(filepath.Dir(db.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create install database dir: %w", err)
+	}
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal install database: %w", err)
+	}
+	tmp := db.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write install database: %w", err)
+	}
+	return os.Rename(tmp, db.path)
+}
+
+// get returns the records of the formula, newest first.
+func (db *installDB) get(name string) []*installRecord {
+	var recs []*installRecord
+	for _, r := range db.Kegs {
+		if r.Name == name {
+			recs = append(recs, r)
+		}
+	}
+	slices.SortFunc(recs, func(a, b *installRecord) int { return b.InstalledAt.Compare(a.InstalledAt) })
+	return recs
+}
+
+// put adds or replaces the record for r's keg.
+func (db *installDB) put(r *installRecord) {
+	db.remove(r)
+	db.Kegs = append(db.Kegs, r)
+}
+
+func (db *installDB) remove(r *installRecord) {
+	db.Kegs = slices.DeleteFunc(db.Kegs, func(k *installRecord) bool {
+		return k.Name == r.Name && k.Version == r.Version
+	})
+}
+
+// dependents returns the installed formulae that depend on name.
+func (db *installDB) dependents(name string) []string {
+	var deps []string
+	for _, r := range db.Kegs {
+		if r.Name != name && slices.Contains(r.Dependencies, name) && !slices.Contains(deps, r.Name) {
+			deps = append(deps, r.Name)
+		}
+	}
+	return deps
+}
+
+// rel makes path relative to the prefix for storing in a record.
+func (db *installDB) rel(paths []string) []string {
+	out := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if rel, err := filepath.Rel(db.prefix, p); err == nil {
+			out = append(out, rel)
+		}
+	}
+	return out
+}
+
+// kegFiles lists the files and symlinks inside keg.
+func kegFiles(keg *Keg) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(keg.Path(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keg files: %w", err)
+	}
+	return files, nil
+}
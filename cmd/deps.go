@@ -0,0 +1,375 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+// macOSReleases are the macOS bottle tag names, oldest first.
+var macOSReleases = []string{
+	"el_capitan", "sierra", "high_sierra", "mojave", "catalina",
+	"big_sur", "monterey", "ventura", "sonoma", "sequoia", "tahoe",
+}
+
+// macOSRelease returns the macOS release a bottle tag targets, or "" for
+// Linux tags.
+func macOSRelease(tag string) string {
+	if strings.HasSuffix(tag, "_linux") {
+		return ""
+	}
+	return strings.TrimPrefix(tag, "arm64_")
+}
+
+// runtimeDependencies returns the formulae that must be installed alongside
+// the formula's bottle for tag. On Linux every runtime uses_from_macos entry
+// is a dependency; on macOS it is provided by the system unless the release
+// is older than its bound.
+func (f *Formula) runtimeDependencies(tag string) (deps []string, system []string) {
+	uses, bounds := f.UsesFromMacos, f.UsesFromMacosBounds
+	deps = slices.Clone(f.Dependencies)
+	if v, ok := f.Variations[tag]; ok {
+		if v.Dependencies != nil {
+			deps = slices.Clone(v.Dependencies)
+		}
+		if v.UsesFromMacos != nil {
+			uses, bounds = v.UsesFromMacos, v.UsesFromMacosBounds
+		}
+	}
+
+	release := macOSRelease(tag)
+	for i, u := range uses {
+		if len(u.Contexts) > 0 {
+			continue // build or test only
+		}
+		if slices.Contains(deps, u.Name) {
+			continue
+		}
+		if release != "" {
+			var since string
+			if i < len(bounds) {
+				since = bounds[i].Since
+			}
+			if since == "" || slices.Index(macOSReleases, release) >= slices.Index(macOSReleases, since) {
+				system = append(system, u.Name)
+				continue
+			}
+		}
+		deps = append(deps, u.Name)
+	}
+	return deps, system
+}
+
+// depNode is a formula in a dependency plan.
+type depNode struct {
+	Formula   *Formula
+	Deps      []*depNode
+	System    []string    // uses_from_macos entries provided by macOS
+	Bottle    *BottleFile // nil if there is no bottle for the plan's tag
+	Installed bool        // a keg is poured in the prefix
+	Cached    bool        // the bottle is in the download cache
+	Size      int64       // bottle size, 0 if unknown or not needed
+}
+
+// needsDownload reports whether installing the plan has to fetch n's bottle.
+func (n *depNode) needsDownload() bool {
+	return n.Bottle != nil && !n.Installed && !n.Cached
+}
+
+// depPlan is the runtime dependency closure of a formula for one bottle tag.
+type depPlan struct {
+	Tag   string
+	Root  *depNode
+	Order []*depNode // dependencies first, root last
+}
+
+// planDependencies resolves the runtime dependencies of formula for tag
+// (the host's bottle when empty). Formulae are looked up in the formula index,
+// falling back to the API if it is unavailable.
+func planDependencies(ctx context.Context, formula *Formula, tag string) (*depPlan, error) {
+	if tag == "" {
+		bottle, err := formula.BottleFor("")
+		if err != nil {
+			return nil, err
+		}
+		tag = bottle.Tag
+	}
+	if !knownBottleTag(tag) {
+		return nil, fmt.Errorf("unknown bottle tag '%s'", tag)
+	}
+
+	byName := make(map[string]*Formula)
+	if index, err := cachedFormulaIndex(ctx); err == nil {
+		for i := range index {
+			byName[index[i].Name] = &index[i]
+		}
+	}
+	lookup := func(name string) (*Formula, error) {
+		name = strings.TrimPrefix(name, "homebrew/core/")
+		if f, ok := byName[name]; ok {
+			return f, nil
+		}
+		f, err := getFormula(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dependency '%s': %w", name, err)
+		}
+		byName[name] = f
+		return f, nil
+	}
+
+	plan := &depPlan{Tag: tag}
+	nodes := make(map[string]*depNode)
+	var visit func(f *Formula, path []string) (*depNode, error)
+	visit = func(f *Formula, path []string) (*depNode, error) {
+		if slices.Contains(path, f.Name) {
+			return nil, fmt.Errorf("dependency cycle: %s -> %s", strings.Join(path, " -> "), f.Name)
+		}
+		if n, ok := nodes[f.Name]; ok {
+			return n, nil
+		}
+		path = append(slices.Clip(path), f.Name)
+		n := &depNode{Formula: f}
+		if bottle, err := f.BottleFor(tag); err == nil {
+			n.Bottle = bottle
+		}
+		deps, system := f.runtimeDependencies(tag)
+		n.System = system
+		for _, name := range deps {
+			dep, err := lookup(name)
+			if err != nil {
+				return nil, err
+			}
+			child, err := visit(dep, path)
+			if err != nil {
+				return nil, err
+			}
+			n.Deps = append(n.Deps, child)
+		}
+		nodes[f.Name] = n
+		plan.Order = append(plan.Order, n)
+		return n, nil
+	}
+
+	root, err := visit(formula, nil)
+	if err != nil {
+		return nil, err
+	}
+	plan.Root = root
+	return plan, nil
+}
+
+// Dependencies returns the plan without its root formula, in install order.
+func (p *depPlan) Dependencies() []*depNode {
+	return p.Order[:len(p.Order)-1]
+}
+
+// maxSizeLookups caps the bottle index requests annotate makes at once.
+const maxSizeLookups = 8
+
+// annotate marks the nodes already poured into prefix (if set) or in the
+// download cache, and looks up the size of every bottle left to download.
+func (p *depPlan) annotate(ctx context.Context, prefix string) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxSizeLookups)
+	for _, n := range p.Order {
+		if prefix != "" {
+			if keg, err := findKeg(prefix, n.Formula.Name); err == nil && keg.Version == n.Formula.pkgVersion() {
+				n.Installed = true
+			}
+		}
+		if n.Bottle != nil {
+			if dst, err := downloadPath(n.Formula, n.Bottle, true); err == nil {
+				n.Cached = cachedBottle(n.Formula, n.Bottle, dst) != nil
+			}
+		}
+		if !n.needsDownload() {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			index, err := getBottleIndex(ctx, n.Formula)
+			if err != nil {
+				logger.Debug("Failed to get bottle size", "formula", n.Formula.Name, "err", err)
+				return
+			}
+			if mf := index.Manifest(p.Tag); mf != nil {
+				n.Size = mf.BottleSize()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// downloadSize sums the bottles left to download and counts them.
+func (p *depPlan) downloadSize() (size int64, count int) {
+	for _, n := range p.Order {
+		if n.needsDownload() {
+			size += n.Size
+			count++
+		}
+	}
+	return size, count
+}
+
+// treeRow is a line of a rendered dependency tree.
+type treeRow struct {
+	node      *depNode
+	path      string // formula names from below the root down to node, "/"-separated
+	prefix    string // indentation and branch drawn before the label
+	collapsed bool   // node has dependencies that are hidden
+}
+
+// treeRows flattens the dependencies of n into tree lines, descending into a
+// node only when expanded reports its path as open.
+func treeRows(n *depNode, indent, path string, expanded func(path string) bool) []treeRow {
+	var rows []treeRow
+	for i, d := range n.Deps {
+		branch, next := "├── ", "│   "
+		if i == len(n.Deps)-1 {
+			branch, next = "└── ", "    "
+		}
+		p := path + "/" + d.Formula.Name
+		open := expanded(p)
+		rows = append(rows, treeRow{node: d, path: p, prefix: indent + branch, collapsed: !open && len(d.Deps) > 0})
+		if open {
+			rows = append(rows, treeRows(d, indent+next, p, expanded)...)
+		}
+	}
+	return rows
+}
+
+// depLabel is the line `deps --tree` prints for n: its name and whether its
+// bottle is missing, installed, cached or how big a download it is.
+func depLabel(n *depNode) string {
+	label := n.Formula.Name
+	switch {
+	case n.Bottle == nil:
+		label += " [no bottle]"
+	case n.Installed:
+		label += " [installed]"
+	case n.Cached:
+		label += " [cached]"
+	case n.Size > 0:
+		label += " (" + humanize.Bytes(uint64(n.Size)) + ")"
+	}
+	return label
+}
+
+// depTreeJSON is a node of `deps --tree --json`.
+type depTreeJSON struct {
+	Name         string        `json:"name"`
+	Version      string        `json:"version"`
+	Bottle       bool          `json:"bottle"`
+	Installed    bool          `json:"installed"`
+	Cached       bool          `json:"cached"`
+	Size         int64         `json:"size,omitempty"`
+	System       []string      `json:"system,omitempty"`
+	Dependencies []depTreeJSON `json:"dependencies,omitempty"`
+}
+
+func (n *depNode) treeJSON() depTreeJSON {
+	t := depTreeJSON{
+		Name:      n.Formula.Name,
+		Version:   n.Formula.pkgVersion(),
+		Bottle:    n.Bottle != nil,
+		Installed: n.Installed,
+		Cached:    n.Cached,
+		Size:      n.Size,
+		System:    n.System,
+	}
+	for _, d := range n.Deps {
+		t.Dependencies = append(t.Dependencies, d.treeJSON())
+	}
+	return t
+}
+
+// depsCmd represents the deps command
+var depsCmd = &cobra.Command{
+	Use:           "deps <formula>",
+	Short:         "List the runtime dependencies of a bottle",
+	Args:          cobra.ExactArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tag, _ := cmd.Flags().GetString("tag")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		tree, _ := cmd.Flags().GetBool("tree")
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+
+		formula, err := getFormula(ctx, args[0])
+		if err != nil {
+			return fmt.Errorf("failed to get formula '%s': %w", args[0], err)
+		}
+		plan, err := planDependencies(ctx, formula, tag)
+		if err != nil {
+			return err
+		}
+
+		if tree {
+			prefix, _ := prefixFlag(cmd)
+			plan.annotate(ctx, prefix)
+			size, count := plan.downloadSize()
+			if asJSON {
+				return printJSON(map[string]any{
+					"tag":           plan.Tag,
+					"download_size": size,
+					"tree":          plan.Root.treeJSON(),
+				})
+			}
+			var b strings.Builder
+			b.WriteString(depLabel(plan.Root) + "\n")
+			for _, r := range treeRows(plan.Root, "", "", func(string) bool { return true }) {
+				b.WriteString(r.prefix + depLabel(r.node) + "\n")
+			}
+			fmt.Print(b.String())
+			fmt.Printf("\n%d bottles to download for %s", count, plan.Tag)
+			if size > 0 {
+				fmt.Printf(", %s total", humanize.Bytes(uint64(size)))
+			}
+			fmt.Println()
+			return nil
+		}
+
+		if asJSON {
+			deps := []string{}
+			for _, n := range plan.Dependencies() {
+				deps = append(deps, n.Formula.Name)
+			}
+			system := []string{}
+			for _, n := range plan.Order {
+				for _, s := range n.System {
+					if !slices.Contains(system, s) {
+						system = append(system, s)
+					}
+				}
+			}
+			return printJSON(map[string]any{
+				"formula":      formula.Name,
+				"tag":          plan.Tag,
+				"dependencies": deps,
+				"system":       system,
+			})
+		}
+		for _, n := range plan.Dependencies() {
+			fmt.Println(n.Formula.Name)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(depsCmd)
+	depsCmd.Flags().StringP("tag", "t", "", "Bottle tag to resolve for (defaults to the host)")
+	depsCmd.Flags().Bool("json", false, "Print the dependencies as JSON")
+	depsCmd.Flags().Bool("tree", false, "Show the dependencies as a tree, marking what is installed or cached")
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTreeRows(t *testing.T) {
+	node := func(name string, deps ...*depNode) *depNode {
+		return &depNode{Formula: &Formula{Name: name}, Deps: deps}
+	}
+	zlib := node("zlib")
+	root := node("git", node("curl", node("openssl", node("ca-certificates")), zlib), node("pcre2"), zlib)
+
+	tests := []struct {
+		name     string
+		expanded []string
+		want     []string
+	}{
+		{"collapsed", nil, []string{
+			"├── curl ▸",
+			"├── pcre2",
+			"└── zlib",
+		}},
+		{"one node", []string{"/curl"}, []string{
+			"├── curl",
+			"│   ├── openssl ▸",
+			"│   └── zlib",
+			"├── pcre2",
+			"└── zlib",
+		}},
+		{"closed parent hides open child", []string{"/curl/openssl"}, []string{
+			"├── curl ▸",
+			"├── pcre2",
+			"└── zlib",
+		}},
+		{"nested", []string{"/curl", "/curl/openssl"}, []string{
+			"├── curl",
+			"│   ├── openssl",
+			"│   │   └── ca-certificates",
+			"│   └── zlib",
+			"├── pcre2",
+			"└── zlib",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, r := range treeRows(root, "", "", func(p string) bool { return slices.Contains(tt.expanded, p) }) {
+				line := r.prefix + r.node.Formula.Name
+				if r.collapsed {
+					line += " ▸"
+				}
+				got = append(got, line)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+// diffEntry is a bottle entry with what `diff` compares beyond its header.
+type diffEntry struct {
+	bottleEntry
+	Sha256 string      `json:"sha256,omitempty"`
+	Binary *binaryInfo `json:"binary,omitempty"`
+}
+
+// diffSide is one of the bottles being compared.
+type diffSide struct {
+	Version string `json:"version"`
+	Tag     string `json:"tag"`
+	Sha256  string `json:"sha256"`
+
+	bottle  *BottleFile
+	entries map[string]*diffEntry
+}
+
+func (s *diffSide) String() string {
+	return fmt.Sprintf("%s (%s)", s.Version, s.Tag)
+}
+
+// diffChange is an entry present in both bottles that differs.
+type diffChange struct {
+	Path      string     `json:"path"`
+	Changes   []string   `json:"changes"`
+	From      *diffEntry `json:"from"`
+	To        *diffEntry `json:"to"`
+	Libraries struct {
+		Added   []string `json:"added,omitempty"`
+		Removed []string `json:"removed,omitempty"`
+	} `json:"libraries,omitzero"`
+}
+
+// isBottleTag reports whether s names a bottle tag rather than a version.
+func isBottleTag(s string) bool {
+	if s == "x86_64_linux" || s == "arm64_linux" {
+		return true
+	}
+	return slices.Contains(macOSReleases, macOSRelease(s))
+}
+
+// resolveDiffSide finds the bottle for a --from/--to value: a version, a tag,
+// or <version>:<tag>. Versions other than the current one are looked up in
+// the formula's image tags on ghcr.io.
+func resolveDiffSide(ctx context.Context, formula *Formula, spec, tag string) (*diffSide, error) {
+	version := spec
+	if v, t, ok := strings.Cut(spec, ":"); ok {
+		version, tag = v, t
+	} else if isBottleTag(spec) {
+		version, tag = "", spec
+	}
+
+	current := version == "" || version == formula.Versions.Stable || version == formula.pkgVersion() || version == formula.imageTag()
+	if current {
+		bottle, err := formula.BottleFor(tag)
+		if err == nil {
+			return &diffSide{Version: formula.imageTag(), Tag: bottle.Tag, Sha256: bottle.Sha256, bottle: bottle}, nil
+		}
+		if tag == "" || !errors.Is(err, errNoBottle) {
+			return nil, err
+		}
+		// the API only lists some tags, the image index has all of them
+		version = formula.imageTag()
+	}
+	if tag == "" {
+		host, err := formula.BottleFor("")
+		if err != nil {
+			return nil, err
+		}
+		tag = host.Tag
+	}
+
+	ref := version
+	index, err := getBottleIndexAt(ctx, formula, ref)
+	if errors.Is(err, errNoBottle) {
+		if ref, err = imageTagFor(ctx, formula, version); err != nil {
+			return nil, err
+		}
+		index, err = getBottleIndexAt(ctx, formula, ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	bottle, err := index.BottleFile(formula, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &diffSide{Version: ref, Tag: tag, Sha256: bottle.Sha256, bottle: bottle}, nil
+}
+
+// snapshotBottle reads every entry of a gzipped bottle by its keg relative
+// path, hashing files and parsing the Mach-O and ELF ones.
+func snapshotBottle(r io.Reader) (map[string]*diffEntry, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip: %w", err)
+	}
+	defer gz.Close()
+
+	entries := make(map[string]*diffEntry)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar: %w", err)
+		}
+		name := path.Clean(hdr.Name)
+		rel := kegPath(name)
+		if rel == "" || hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		e := &diffEntry{bottleEntry: bottleEntry{
+			Path: rel,
+			Size: hdr.Size,
+			Mode: fmt.Sprintf("%04o", hdr.Mode&0o7777),
+			Link: hdr.Linkname,
+		}}
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			e.Type = "symlink"
+		case tar.TypeLink:
+			e.Type = "hardlink"
+			e.Link = kegPath(path.Clean(hdr.Linkname))
+		case tar.TypeReg:
+			e.Type = "file"
+			if err := e.read(tr, name); err != nil {
+				return nil, err
+			}
+		default:
+			e.Type = string(hdr.Typeflag)
+		}
+		entries[rel] = e
+	}
+	// drain the gzip stream so a checksumReader sees EOF
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return nil, fmt.Errorf("failed to read gzip: %w", err)
+	}
+	return entries, nil
+}
+
+// read hashes a file's content, keeping binaries in memory to parse them.
+// Library paths into the file's own keg are made version independent.
+func (e *diffEntry) read(r io.Reader, name string) error {
+	h := sha256.New()
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(r, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	magic = magic[:n]
+	if isBinary(magic) {
+		rest, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		data := append(magic, rest...)
+		h.Write(data)
+		if e.Binary = parseBinary(data); e.Binary != nil {
+			parts := strings.SplitN(name, "/", 3)
+			keg := "/" + parts[0] + "/" + parts[1] + "/"
+			for i, lib := range e.Binary.Libraries {
+				e.Binary.Libraries[i] = strings.Replace(lib, keg, "/"+parts[0]+"/{version}/", 1)
+			}
+			slices.Sort(e.Binary.Libraries)
+		}
+	} else {
+		h.Write(magic)
+		if _, err := io.Copy(h, r); err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+	}
+	e.Sha256 = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// compareEntries lists how an entry changed between two bottles, or nil.
+func compareEntries(from, to *diffEntry) *diffChange {
+	c := &diffChange{Path: from.Path, From: from, To: to}
+	if from.Type != to.Type {
+		c.Changes = append(c.Changes, fmt.Sprintf("type %s -> %s", from.Type, to.Type))
+	}
+	if from.Mode != to.Mode {
+		c.Changes = append(c.Changes, fmt.Sprintf("mode %s -> %s", from.Mode, to.Mode))
+	}
+	if from.Link != to.Link {
+		c.Changes = append(c.Changes, fmt.Sprintf("link %s -> %s", from.Link, to.Link))
+	}
+	if from.Size != to.Size {
+		c.Changes = append(c.Changes, fmt.Sprintf("size %s -> %s", humanize.Bytes(uint64(from.Size)), humanize.Bytes(uint64(to.Size))))
+	} else if from.Sha256 != to.Sha256 {
+		c.Changes = append(c.Changes, "content")
+	}
+	if from.Binary != nil && to.Binary != nil {
+		if !slices.Equal(from.Binary.Arch, to.Binary.Arch) {
+			c.Changes = append(c.Changes, fmt.Sprintf("arch %s -> %s", strings.Join(from.Binary.Arch, ","), strings.Join(to.Binary.Arch, ",")))
+		}
+		for _, lib := range to.Binary.Libraries {
+			if !slices.Contains(from.Binary.Libraries, lib) {
+				c.Libraries.Added = append(c.Libraries.Added, lib)
+			}
+		}
+		for _, lib := range from.Binary.Libraries {
+			if !slices.Contains(to.Binary.Libraries, lib) {
+				c.Libraries.Removed = append(c.Libraries.Removed, lib)
+			}
+		}
+		if len(c.Libraries.Added) > 0 || len(c.Libraries.Removed) > 0 {
+			c.Changes = append(c.Changes, "libraries")
+		}
+	}
+	if len(c.Changes) == 0 {
+		return nil
+	}
+	return c
+}
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <formula>",
+	Short: "Compare the contents of two bottles of a formula",
+	Long: `Compare the contents of two bottles of a formula.
+
+--from and --to take a version (1.7.1), a bottle tag (arm64_sonoma) or both
+(1.7.1:arm64_sonoma). A missing version is the current one and a missing tag
+is --tag or the host.`,
+	Args:          cobra.ExactArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		tag, _ := cmd.Flags().GetString("tag")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+
+		formula, err := getFormula(ctx, args[0])
+		if err != nil {
+			return fmt.Errorf("failed to get formula '%s': %w", args[0], err)
+		}
+		sides := make([]*diffSide, 2)
+		for i, spec := range []string{from, to} {
+			if sides[i], err = resolveDiffSide(ctx, formula, spec, tag); err != nil {
+				return err
+			}
+		}
+		if sides[0].Sha256 == sides[1].Sha256 {
+			logger.Info("Bottles are identical", "from", sides[0], "to", sides[1])
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i, s := range sides {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r, err := openFormulaBottle(ctx, formula, s.bottle)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				defer r.Close()
+				s.entries, errs[i] = snapshotBottle(r)
+			}()
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+
+		var added, removed []*diffEntry
+		var changed []*diffChange
+		for p, e := range sides[1].entries {
+			if _, ok := sides[0].entries[p]; !ok {
+				added = append(added, e)
+			}
+		}
+		for p, e := range sides[0].entries {
+			other, ok := sides[1].entries[p]
+			if !ok {
+				removed = append(removed, e)
+			} else if c := compareEntries(e, other); c != nil {
+				changed = append(changed, c)
+			}
+		}
+		byPath := func(a, b *diffEntry) int { return strings.Compare(a.Path, b.Path) }
+		slices.SortFunc(added, byPath)
+		slices.SortFunc(removed, byPath)
+		slices.SortFunc(changed, func(a, b *diffChange) int { return strings.Compare(a.Path, b.Path) })
+
+		if asJSON {
+			return printJSON(map[string]any{
+				"formula": formula.Name,
+				"from":    sides[0],
+				"to":      sides[1],
+				"added":   append([]*diffEntry{}, added...),
+				"removed": append([]*diffEntry{}, removed...),
+				"changed": append([]*diffChange{}, changed...),
+			})
+		}
+
+		fmt.Printf("--- %s %s\n+++ %s %s\n", formula.Name, sides[0], formula.Name, sides[1])
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, e := range added {
+			fmt.Fprintf(w, "A\t%s\t%s\n", e.Path, humanize.Bytes(uint64(e.Size)))
+		}
+		for _, e := range removed {
+			fmt.Fprintf(w, "D\t%s\t%s\n", e.Path, humanize.Bytes(uint64(e.Size)))
+		}
+		for _, c := range changed {
+			fmt.Fprintf(w, "M\t%s\t%s\n", c.Path, strings.Join(c.Changes, ", "))
+			for _, lib := range c.Libraries.Removed {
+				fmt.Fprintf(w, "\t\t- %s\n", lib)
+			}
+			for _, lib := range c.Libraries.Added {
+				fmt.Fprintf(w, "\t\t+ %s\n", lib)
+			}
+		}
+		w.Flush()
+		fmt.Printf("%d added, %d removed, %d changed\n", len(added), len(removed), len(changed))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().String("from", "", "Version and/or tag of the old bottle")
+	diffCmd.Flags().String("to", "", "Version and/or tag of the new bottle")
+	diffCmd.Flags().StringP("tag", "t", "", "Bottle tag for sides given only a version (defaults to the host)")
+	diffCmd.Flags().Bool("json", false, "Print the differences as JSON")
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// downloadResult describes a downloaded bottle and is what `--json` prints.
+type downloadResult struct {
+	Formula  string  `json:"formula"`
+	Version  string  `json:"version"`
+	Tag      string  `json:"tag"`
+	Path     string  `json:"path"`
+	Sha256   string  `json:"sha256"`
+	Size     int64   `json:"size"`
+	Duration float64 `json:"duration"` // seconds
+	Keg      string  `json:"keg,omitempty"`
+	Attested bool    `json:"attested,omitempty"`
+	SBOM     string  `json:"sbom,omitempty"`
+	Caveats  string  `json:"caveats,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	ExitCode int     `json:"exit_code,omitempty"`
+
+	Deprecated        bool   `json:"deprecated,omitempty"`
+	DeprecationReason string `json:"deprecation_reason,omitempty"`
+	Disabled          bool   `json:"disabled,omitempty"`
+	DisableReason     string `json:"disable_reason,omitempty"`
+}
+
+// setStatus copies the formula's deprecated and disabled state to r.
+func (r *downloadResult) setStatus(f *Formula) {
+	r.Deprecated, r.DeprecationReason = f.Deprecated, f.DeprecationReason
+	r.Disabled, r.DisableReason = f.Disabled, f.DisableReason
+}
+
+// fetchBottle downloads a bottle to dst and verifies its sha256. onProgress,
+// if set, is called with the completed ratio as the body is read.
+func fetchBottle(ctx context.Context, formula *Formula, bottle *BottleFile, dst string, onProgress func(float64)) (*downloadResult, error) {
+	start := time.Now()
+	if res := cachedBottle(formula, bottle, dst); res != nil {
+		return res, nil
+	}
+	resp, err := openBottle(ctx, bottle)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return saveBottle(formula, bottle, resp, dst, start, onProgress)
+}
+
+// downloadPath is where a bottle is saved: the working directory, or the
+// download cache when it is going to be poured into a prefix.
+func downloadPath(formula *Formula, bottle *BottleFile, cached bool) (string, error) {
+	if !cached {
+		return formula.Name + ".tar.gz", nil
+	}
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "downloads")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create download cache: %w", err)
+	}
+	name := fmt.Sprintf("%s--%s.%s.bottle.tar.gz", formula.Name, formula.pkgVersion(), bottle.Tag)
+	if rebuild := formula.Bottle.Stable.Rebuild; rebuild > 0 {
+		name = fmt.Sprintf("%s--%s.%s.bottle.%d.tar.gz", formula.Name, formula.pkgVersion(), bottle.Tag, rebuild)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// cachedBottle returns the result for dst if it already holds the bottle.
+func cachedBottle(formula *Formula, bottle *BottleFile, dst string) *downloadResult {
+	if bottle.Sha256 == "" {
+		return nil
+	}
+	f, err := os.Open(dst)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil || hex.EncodeToString(h.Sum(nil)) != bottle.Sha256 {
+		return nil
+	}
+	return &downloadResult{
+		Formula: formula.Name,
+		Version: formula.Versions.Stable,
+		Tag:     bottle.Tag,
+		Path:    dst,
+		Sha256:  bottle.Sha256,
+		Size:    size,
+	}
+}
+
+// openBottle requests a bottle blob and checks the response status. The body
+// is read under ctx, so canceling it aborts the transfer.
+func openBottle(ctx context.Context, bottle *BottleFile) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", bottle.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Authorization", "Bearer QQ==")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to http GET: %w", errNetwork, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s returned %s", errNoBottle, bottle.URL, resp.Status)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s returned %s", errNetwork, bottle.URL, resp.Status)
+	}
+}
+
+// saveBottle writes the response body of openBottle to a temp file next to
+// dst and renames it into place once the sha256 matches, so dst never holds a
+// partial or corrupt bottle.
+func saveBottle(formula *Formula, bottle *BottleFile, resp *http.Response, dst string, start time.Time, onProgress func(float64)) (*downloadResult, error) {
+	f, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	tmp := f.Name()
+	defer func() {
+		f.Close()
+		os.Remove(tmp)
+	}()
+
+	h := sha256.New()
+	pw := &progressWriter{
+		total:      int(resp.ContentLength),
+		onProgress: onProgress,
+	}
+	size, err := io.Copy(io.MultiWriter(f, h, pw), resp.Body)
+	if err != nil {
+		if ctx := resp.Request.Context(); ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: download aborted: %w", errCanceled, ctx.Err())
+		}
+		return nil, fmt.Errorf("%w: failed to download bottle: %w", errNetwork, err)
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	if bottle.Sha256 != "" && sum != bottle.Sha256 {
+		return nil, fmt.Errorf("%w: expected %s, got %s", errChecksum, bottle.Sha256, sum)
+	}
+	if err := f.Chmod(0o644); err != nil {
+		return nil, fmt.Errorf("failed to chmod file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return nil, fmt.Errorf("failed to save bottle: %w", err)
+	}
+
+	return &downloadResult{
+		Formula:  formula.Name,
+		Version:  formula.Versions.Stable,
+		Tag:      bottle.Tag,
+		Path:     dst,
+		Sha256:   sum,
+		Size:     size,
+		Duration: time.Since(start).Seconds(),
+	}, nil
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveBottle(t *testing.T) {
+	const body = "bottle"
+	sum := sha256.Sum256([]byte(body))
+	tests := []struct {
+		name    string
+		sha256  string
+		wantErr error
+	}{
+		{"match", hex.EncodeToString(sum[:]), nil},
+		{"mismatch", strings.Repeat("0", 64), errChecksum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dst := filepath.Join(dir, "tool--1.0.x86_64_linux.bottle.tar.gz")
+			req, _ := http.NewRequest("GET", "https://example.invalid/bottle", nil)
+			resp := &http.Response{Body: io.NopCloser(strings.NewReader(body)), ContentLength: int64(len(body)), Request: req}
+			formula := &Formula{Name: "tool"}
+			_, err := saveBottle(formula, &BottleFile{Sha256: tt.sha256}, resp, dst, time.Now(), nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			entries, _ := os.ReadDir(dir)
+			if tt.wantErr != nil {
+				if len(entries) != 0 {
+					t.Errorf("left %s behind", entries[0].Name())
+				}
+				return
+			}
+			if len(entries) != 1 || entries[0].Name() != filepath.Base(dst) {
+				t.Errorf("cache dir holds %v, want only %s", entries, filepath.Base(dst))
+			}
+		})
+	}
+}
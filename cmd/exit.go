@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+)
+
+// Exit codes returned to the shell so scripts can tell failures apart.
+const (
+	exitOK       = 0
+	exitFailure  = 1
+	exitNotFound = 2
+	exitNoBottle = 3
+	exitChecksum = 4
+	exitNetwork  = 5
+	exitVerify   = 6
+	exitLicense  = 7
+	exitUnsafe   = 8
+	exitDisabled = 9
+	exitCanceled = 130
+)
+
+var (
+	errNotFound = errors.New("formula not found")
+	errNoBottle = errors.New("no bottle for platform")
+	errChecksum = errors.New("checksum mismatch")
+	errNetwork  = errors.New("network failure")
+	errVerify   = errors.New("verification failed")
+	errLicense  = errors.New("license policy violation")
+	errUnsafe   = errors.New("unsafe bottle contents")
+	errCanceled = errors.New("canceled")
+)
+
+// exitCode maps an error returned by a command to the process exit code.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errCanceled), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return exitCanceled
+	case errors.Is(err, errNotFound):
+		return exitNotFound
+	case errors.Is(err, errNoBottle):
+		return exitNoBottle
+	case errors.Is(err, errChecksum):
+		return exitChecksum
+	case errors.Is(err, errNetwork):
+		return exitNetwork
+	case errors.Is(err, errVerify):
+		return exitVerify
+	case errors.Is(err, errLicense):
+		return exitLicense
+	case errors.Is(err, errUnsafe):
+		return exitUnsafe
+	case errors.Is(err, errDisabled):
+		return exitDisabled
+	default:
+		return exitFailure
+	}
+}
@@ -0,0 +1,532 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	ociManifest      = "application/vnd.oci.image.manifest.v1+json"
+	ociConfig        = "application/vnd.oci.image.config.v1+json"
+	ociLayerGzip     = "application/vnd.oci.image.layer.v1.tar+gzip"
+	ociRefName       = "org.opencontainers.image.ref.name"
+	dockerManifest   = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerList       = "application/vnd.docker.distribution.manifest.list.v2+json"
+	linuxPrefix      = "/home/linuxbrew/.linuxbrew"
+	defaultImagePath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociImageManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociLayout is an OCI image layout directory: oci-layout, index.json and
+// content addressed blobs.
+type ociLayout string
+
+func (l ociLayout) blobPath(digest string) string {
+	algo, encoded, _ := strings.Cut(digest, ":")
+	return filepath.Join(string(l), "blobs", algo, encoded)
+}
+
+func (l ociLayout) readBlob(digest string) ([]byte, error) {
+	data, err := os.ReadFile(l.blobPath(digest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+	return data, nil
+}
+
+// writeBlob stores data and returns its descriptor.
+func (l ociLayout) writeBlob(mediaType string, data []byte) (ociDescriptor, error) {
+	sum := sha256.Sum256(data)
+	desc := ociDescriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(data))}
+	dst := l.blobPath(desc.Digest)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return desc, fmt.Errorf("failed to create blobs dir: %w", err)
+	}
+	if err := os.WriteFile(dst, data, 0o644); err != nil {
+		return desc, fmt.Errorf("failed to write blob: %w", err)
+	}
+	return desc, nil
+}
+
+// copyBlob copies a blob from another layout unless it is already present.
+func (l ociLayout) copyBlob(from ociLayout, digest string) error {
+	dst := l.blobPath(digest)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("failed to create blobs dir: %w", err)
+	}
+	return copyFile(from.blobPath(digest), dst)
+}
+
+// readIndex reads index.json, returning an empty index for a new layout.
+func (l ociLayout) readIndex() (*ociIndex, error) {
+	idx := &ociIndex{SchemaVersion: 2, MediaType: ociImageIndex}
+	data, err := os.ReadFile(filepath.Join(string(l), "index.json"))
+	if os.IsNotExist(err) {
+		return idx, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read index.json: %w", err)
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("failed to parse %s/index.json: %w", l, err)
+	}
+	return idx, nil
+}
+
+// writeIndex writes index.json and the oci-layout marker.
+func (l ociLayout) writeIndex(idx *ociIndex) error {
+	if err := os.MkdirAll(string(l), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", l, err)
+	}
+	if err := os.WriteFile(filepath.Join(string(l), "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write oci-layout: %w", err)
+	}
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(string(l), "index.json"), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write index.json: %w", err)
+	}
+	return nil
+}
+
+// imageManifest finds the image manifest for platform in the layout,
+// descending into nested indexes.
+func (l ociLayout) imageManifest(platform ociPlatform) (*ociImageManifest, error) {
+	idx, err := l.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	var find func(descs []ociDescriptor) (*ociImageManifest, error)
+	find = func(descs []ociDescriptor) (*ociImageManifest, error) {
+		for _, d := range descs {
+			if d.Platform != nil && (d.Platform.OS != platform.OS || d.Platform.Architecture != platform.Architecture) {
+				continue
+			}
+			data, err := l.readBlob(d.Digest)
+			if err != nil {
+				return nil, err
+			}
+			switch d.MediaType {
+			case ociImageIndex, dockerList:
+				var nested ociIndex
+				if err := json.Unmarshal(data, &nested); err != nil {
+					return nil, fmt.Errorf("failed to parse index %s: %w", d.Digest, err)
+				}
+				if m, err := find(nested.Manifests); m != nil || err != nil {
+					return m, err
+				}
+			case ociManifest, dockerManifest:
+				var m ociImageManifest
+				if err := json.Unmarshal(data, &m); err != nil {
+					return nil, fmt.Errorf("failed to parse manifest %s: %w", d.Digest, err)
+				}
+				return &m, nil
+			}
+		}
+		return nil, nil
+	}
+	m, err := find(idx.Manifests)
+	if err == nil && m == nil {
+		err = fmt.Errorf("%s has no image for %s/%s", l, platform.OS, platform.Architecture)
+	}
+	return m, err
+}
+
+// writeLayer tars rootfs into a gzipped layer blob and returns its
+// descriptor and the digest of the uncompressed tar (the diff ID). Owners
+// and times are reset so the same kegs give the same layer.
+func (l ociLayout) writeLayer(rootfs string) (ociDescriptor, string, error) {
+	desc := ociDescriptor{MediaType: ociLayerGzip}
+	dir := filepath.Join(string(l), "blobs", "sha256")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return desc, "", fmt.Errorf("failed to create blobs dir: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".layer-")
+	if err != nil {
+		return desc, "", fmt.Errorf("failed to create layer: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	compressed, uncompressed := sha256.New(), sha256.New()
+	counter := &countWriter{w: io.MultiWriter(f, compressed)}
+	gz := gzip.NewWriter(counter)
+	tw := tar.NewWriter(io.MultiWriter(gz, uncompressed))
+
+	err = filepath.WalkDir(rootfs, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == rootfs {
+			return err
+		}
+		return addToLayer(tw, rootfs, p, d)
+	})
+	if err != nil {
+		return desc, "", err
+	}
+	if err := tw.Close(); err != nil {
+		return desc, "", fmt.Errorf("failed to write layer: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return desc, "", fmt.Errorf("failed to write layer: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return desc, "", fmt.Errorf("failed to write layer: %w", err)
+	}
+
+	desc.Digest = "sha256:" + hex.EncodeToString(compressed.Sum(nil))
+	desc.Size = counter.n
+	if err := os.Rename(f.Name(), l.blobPath(desc.Digest)); err != nil {
+		return desc, "", fmt.Errorf("failed to write layer: %w", err)
+	}
+	return desc, "sha256:" + hex.EncodeToString(uncompressed.Sum(nil)), nil
+}
+
+func addToLayer(tw *tar.Writer, rootfs, p string, d fs.DirEntry) error {
+	fi, err := d.Info()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", p, err)
+	}
+	var link string
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(p); err != nil {
+			return fmt.Errorf("failed to read link %s: %w", p, err)
+		}
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to layer: %w", p, err)
+	}
+	rel, _ := filepath.Rel(rootfs, p)
+	hdr.Name = filepath.ToSlash(rel)
+	if d.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	hdr.ModTime, hdr.AccessTime, hdr.ChangeTime = time.Unix(0, 0), time.Time{}, time.Time{}
+	hdr.Format = tar.FormatPAX
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to add %s to layer: %w", p, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", p, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to add %s to layer: %w", p, err)
+	}
+	return nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// imageConfig adds the layer to the base image config (or a new scratch
+// config) and puts the prefix's bin and sbin first on PATH.
+func imageConfig(base map[string]any, platform ociPlatform, diffID, prefix, createdBy string) (map[string]any, error) {
+	cfg := base
+	if cfg == nil {
+		cfg = map[string]any{
+			"architecture": platform.Architecture,
+			"os":           platform.OS,
+		}
+	} else if arch, _ := cfg["architecture"].(string); arch != platform.Architecture {
+		return nil, fmt.Errorf("base image is %s, bottles are %s", arch, platform.Architecture)
+	}
+	created := time.Now().UTC().Format(time.RFC3339)
+	cfg["created"] = created
+
+	rootfs, _ := cfg["rootfs"].(map[string]any)
+	if rootfs == nil {
+		rootfs = map[string]any{"type": "layers"}
+	}
+	diffIDs, _ := rootfs["diff_ids"].([]any)
+	rootfs["diff_ids"] = append(diffIDs, diffID)
+	cfg["rootfs"] = rootfs
+
+	history, _ := cfg["history"].([]any)
+	cfg["history"] = append(history, map[string]any{"created": created, "created_by": createdBy})
+
+	config, _ := cfg["config"].(map[string]any)
+	if config == nil {
+		config = make(map[string]any)
+	}
+	env, _ := config["Env"].([]any)
+	binPath := path.Join(prefix, "bin") + ":" + path.Join(prefix, "sbin")
+	found := false
+	for i, e := range env {
+		if s, _ := e.(string); strings.HasPrefix(s, "PATH=") {
+			if !strings.Contains(s, binPath) {
+				env[i] = "PATH=" + binPath + ":" + strings.TrimPrefix(s, "PATH=")
+			}
+			found = true
+		}
+	}
+	if !found {
+		env = append(env, "PATH="+binPath+":"+defaultImagePath)
+	}
+	config["Env"] = env
+	cfg["config"] = config
+	return cfg, nil
+}
+
+// systemLoaders is the glibc dynamic loader of a base image by architecture.
+var systemLoaders = map[string]string{
+	"amd64": "/lib64/ld-linux-x86-64.so.2",
+	"arm64": "/lib/ld-linux-aarch64.so.1",
+}
+
+// linkLoader links <prefix>/lib/ld.so, the interpreter Linux bottles are
+// relocated to, to the base image's loader like brew does on the host. A
+// loader poured from the glibc formula is left alone.
+func linkLoader(dir, arch string) error {
+	ldso := filepath.Join(dir, "lib", "ld.so")
+	if _, err := os.Lstat(ldso); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(ldso), 0o755); err != nil {
+		return fmt.Errorf("failed to create dir: %w", err)
+	}
+	if err := os.Symlink(systemLoaders[arch], ldso); err != nil {
+		return fmt.Errorf("failed to link ld.so: %w", err)
+	}
+	return nil
+}
+
+// exportImage adds an image of rootfs as a single layer on top of the base
+// layout (or scratch) to layout under ref, returning its manifest.
+func exportImage(layout ociLayout, base, rootfs string, platform ociPlatform, prefix, ref, createdBy string) (ociDescriptor, error) {
+	var baseConfig map[string]any
+	var layers []ociDescriptor
+	if base != "" {
+		baseLayout := ociLayout(base)
+		m, err := baseLayout.imageManifest(platform)
+		if err != nil {
+			return ociDescriptor{}, fmt.Errorf("failed to read base image: %w", err)
+		}
+		data, err := baseLayout.readBlob(m.Config.Digest)
+		if err != nil {
+			return ociDescriptor{}, err
+		}
+		if err := json.Unmarshal(data, &baseConfig); err != nil {
+			return ociDescriptor{}, fmt.Errorf("failed to parse base image config: %w", err)
+		}
+		for _, l := range m.Layers {
+			if err := layout.copyBlob(baseLayout, l.Digest); err != nil {
+				return ociDescriptor{}, err
+			}
+		}
+		layers = m.Layers
+	}
+
+	layer, diffID, err := layout.writeLayer(rootfs)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	layers = append(layers, layer)
+
+	cfg, err := imageConfig(baseConfig, platform, diffID, prefix, createdBy)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return ociDescriptor{}, fmt.Errorf("failed to marshal image config: %w", err)
+	}
+	config, err := layout.writeBlob(ociConfig, data)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	data, err = json.Marshal(ociImageManifest{SchemaVersion: 2, MediaType: ociManifest, Config: config, Layers: layers})
+	if err != nil {
+		return ociDescriptor{}, fmt.Errorf("failed to marshal image manifest: %w", err)
+	}
+	manifest, err := layout.writeBlob(ociManifest, data)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	manifest.Annotations = map[string]string{ociRefName: ref}
+	manifest.Platform = &platform
+
+	idx, err := layout.readIndex()
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	idx.Manifests = slices.DeleteFunc(idx.Manifests, func(d ociDescriptor) bool { return d.Annotations[ociRefName] == ref })
+	idx.Manifests = append(idx.Manifests, manifest)
+	return manifest, layout.writeIndex(idx)
+}
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export <formula>... --oci-layout <dir>",
+	Short: "Export bottles as a layer of an OCI image layout",
+	Long: `Export bottles as a layer of an OCI image layout.
+
+The formulae and their runtime dependencies are poured and linked into
+--prefix (default ` + linuxPrefix + `) inside a single layer, on top of the
+--base OCI layout or an empty (scratch) image. The image is added to the
+--oci-layout directory as --ref, ready for crane, skopeo or podman.`,
+	Args:          cobra.MinimumNArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, _ := cmd.Flags().GetString("oci-layout")
+		base, _ := cmd.Flags().GetString("base")
+		ref, _ := cmd.Flags().GetString("ref")
+		tag, _ := cmd.Flags().GetString("tag")
+		prefix, _ := cmd.Flags().GetString("prefix")
+
+		if tag == "" {
+			tag = "x86_64_linux"
+			if runtime.GOARCH == "arm64" {
+				tag = "arm64_linux"
+			}
+		}
+		if !strings.HasSuffix(tag, "_linux") {
+			return fmt.Errorf("images need a Linux bottle, not '%s'", tag)
+		}
+		platform := ociPlatform{Architecture: "amd64", OS: "linux"}
+		if strings.HasPrefix(tag, "arm64_") {
+			platform.Architecture = "arm64"
+		}
+		if prefix == "" {
+			prefix = linuxPrefix
+		}
+		if !path.IsAbs(prefix) {
+			return fmt.Errorf("--prefix must be an absolute path inside the image")
+		}
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+
+		// the formulae and their dependencies, each once, dependencies first
+		var nodes []*depNode
+		requested := make(map[string]bool)
+		for _, name := range args {
+			formula, err := getFormula(ctx, name)
+			if err != nil {
+				return fmt.Errorf("failed to get formula '%s': %w", name, err)
+			}
+			requested[formula.Name] = true
+			plan, err := planDependencies(ctx, formula, tag)
+			if err != nil {
+				return err
+			}
+			for _, n := range plan.Order {
+				if !slices.ContainsFunc(nodes, func(o *depNode) bool { return o.Formula.Name == n.Formula.Name }) {
+					nodes = append(nodes, n)
+				}
+			}
+		}
+
+		staging, err := os.MkdirTemp("", "bottle-bomb-export-")
+		if err != nil {
+			return fmt.Errorf("failed to create staging dir: %w", err)
+		}
+		defer os.RemoveAll(staging)
+		rootfs := filepath.Join(staging, "rootfs")
+		dir := filepath.Join(rootfs, filepath.FromSlash(prefix))
+
+		for _, n := range nodes {
+			f := n.Formula
+			if n.Bottle == nil {
+				return fmt.Errorf("%w: '%s' has no bottle for tag '%s'", errNoBottle, f.Name, tag)
+			}
+			res, err := downloadNonInteractive(ctx, f, tag, true, false)
+			if err != nil {
+				return err
+			}
+			keg, _, err := pourBottleAs(res.Path, dir, prefix, f, n.Bottle)
+			if err != nil {
+				return fmt.Errorf("failed to pour bottle: %w", err)
+			}
+			index, err := getBottleIndex(ctx, f)
+			if err != nil {
+				logger.Warn("Writing receipt without the bottle manifest", "formula", f.Name, "err", err)
+			}
+			if err := writeReceiptAs(keg, prefix, f, index.Manifest(tag), requested[f.Name]); err != nil {
+				return err
+			}
+			if _, err := linkKeg(keg, f, linkOptions{Globs: f.LinkOverwrite}); err != nil {
+				return err
+			}
+		}
+		if err := linkLoader(dir, platform.Architecture); err != nil {
+			return err
+		}
+		if base == "" {
+			logger.Warn("The image has no base, so there is no glibc for the bottles' binaries (pass --base)")
+		}
+
+		manifest, err := exportImage(ociLayout(out), base, rootfs, platform, prefix, ref, "bottle-bomb export "+strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+		logger.Info("Exported", "layout", out, "ref", ref, "digest", manifest.Digest, "kegs", len(nodes))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().String("oci-layout", "", "OCI image layout directory to write the image to")
+	exportCmd.Flags().String("base", "", "OCI image layout of the base image (default scratch)")
+	exportCmd.Flags().String("ref", "latest", "Reference name of the image in the layout")
+	exportCmd.Flags().StringP("tag", "t", "", "Linux bottle tag (defaults to the host architecture)")
+	exportCmd.MarkFlagRequired("oci-layout")
+}
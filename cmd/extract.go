@@ -0,0 +1,372 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// maxLinkPasses bounds link resolution, like ELOOP.
+const maxLinkPasses = 40
+
+// extractor writes the entries of a bottle matching a set of patterns into a
+// directory, or the only one of them to File. Links are resolved to the files
+// they point at so the output is usable on its own.
+type extractor struct {
+	Out      string
+	File     string // write the single selected file here instead of under Out
+	Flatten  bool
+	Patterns []string
+
+	staging string
+	wanted  map[string][]string // archive path -> destinations
+	dirs    map[string][]string // linked directory -> destinations, this pass
+	seen    map[string]bool     // archive paths already passed
+	written map[string]string   // destination -> archive path
+	staged  map[string]string   // destination -> staged file
+}
+
+// matches reports whether the keg relative path is selected. A pattern
+// matches the path or one of its leading directories.
+func (x *extractor) matches(rel string) bool {
+	for _, p := range x.Patterns {
+		p = strings.Trim(p, "/")
+		for dir := rel; dir != "."; dir = path.Dir(dir) {
+			if ok, _ := path.Match(p, dir); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (x *extractor) dest(rel string) (string, error) {
+	if x.Flatten {
+		rel = path.Base(rel)
+	}
+	dst := filepath.Join(x.Out, filepath.FromSlash(rel))
+	if !within(dst, x.Out) {
+		return "", fmt.Errorf("%w: %s escapes %s", errUnsafe, rel, x.Out)
+	}
+	return dst, nil
+}
+
+// pass reads one gzipped tarball, staging matched files and recording link
+// targets that still have to be found.
+func (x *extractor) pass(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read gzip: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar: %w", err)
+		}
+		name := path.Clean(hdr.Name)
+		dests, err := x.linkedDirDests(name, hdr.Typeflag == tar.TypeDir)
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			x.seen[name] = true
+			continue
+		}
+		dests = append(dests, x.wanted[name]...)
+		delete(x.wanted, name)
+		if rel := kegPath(name); rel != "" && hdr.Typeflag != tar.TypeDir && x.matches(rel) {
+			dst, err := x.dest(rel)
+			if err != nil {
+				return err
+			}
+			dests = append(dests, dst)
+		}
+		if len(dests) == 0 {
+			x.seen[name] = true
+			continue
+		}
+
+		parts := strings.SplitN(name, "/", 3)
+		keg := strings.Join(parts[:min(2, len(parts))], "/")
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if err := x.stage(tr, name, dests, os.FileMode(hdr.Mode&0o777)); err != nil {
+				return err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			target := path.Clean(hdr.Linkname)
+			if hdr.Typeflag == tar.TypeSymlink {
+				target = path.Clean(path.Join(path.Dir(name), hdr.Linkname))
+			}
+			if path.IsAbs(hdr.Linkname) || !within(target, keg) {
+				return fmt.Errorf("%w: %s links outside the keg to %s", errUnsafe, name, hdr.Linkname)
+			}
+			x.wanted[target] = append(x.wanted[target], dests...)
+		default:
+			logger.Warn("Skipping entry", "path", name, "type", string(hdr.Typeflag))
+		}
+		x.seen[name] = true
+	}
+	// drain the gzip stream so a checksumReader sees EOF
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return fmt.Errorf("failed to read gzip: %w", err)
+	}
+	return nil
+}
+
+// linkedDirDests returns where an entry goes because it is inside a directory
+// a link points at. A wanted link target turns out to be a directory when an
+// entry inside it (or the directory's own entry) is seen; it is then expanded
+// into the entries after it, which in a tarball is all of them.
+func (x *extractor) linkedDirDests(name string, isDir bool) ([]string, error) {
+	dir := path.Dir(name)
+	if isDir {
+		dir = name
+	}
+	for ; dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if dests, ok := x.wanted[dir]; ok {
+			x.dirs[dir] = append(x.dirs[dir], dests...)
+			delete(x.wanted, dir)
+		}
+		x.seen[dir] = true
+	}
+	var dests []string
+	for dir, dirDests := range x.dirs {
+		sub, ok := strings.CutPrefix(name, dir+"/")
+		if !ok {
+			continue
+		}
+		for _, d := range dirDests {
+			dst := filepath.Join(d, filepath.FromSlash(sub))
+			if x.Flatten {
+				dst = filepath.Join(x.Out, path.Base(sub))
+			}
+			if !within(dst, x.Out) {
+				return nil, fmt.Errorf("%w: %s escapes %s", errUnsafe, name, x.Out)
+			}
+			dests = append(dests, dst)
+		}
+	}
+	return dests, nil
+}
+
+// stage writes a file's content for each of its destinations into the
+// staging directory.
+func (x *extractor) stage(r io.Reader, name string, dests []string, mode os.FileMode) error {
+	for _, dst := range dests {
+		if src, ok := x.written[dst]; ok && src != name {
+			return fmt.Errorf("both %s and %s would be written to %s", src, name, dst)
+		}
+		x.written[dst] = name
+	}
+	f, err := os.CreateTemp(x.staging, "entry-")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	if err := f.Chmod(mode); err != nil {
+		return fmt.Errorf("failed to chmod %s: %w", name, err)
+	}
+	for _, dst := range dests {
+		x.staged[dst] = f.Name()
+	}
+	return nil
+}
+
+// extract streams src through the extractor. Link targets that come before
+// their link in the archive need another pass; a stream that can't be
+// rewound is spooled to the staging directory on the first one for that.
+func (x *extractor) extract(src io.ReadCloser) ([]string, error) {
+	if err := os.MkdirAll(x.Out, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", x.Out, err)
+	}
+	staging, err := os.MkdirTemp(x.Out, ".extract-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging dir: %w", err)
+	}
+	defer os.RemoveAll(staging)
+	x.staging = staging
+	x.wanted = make(map[string][]string)
+	x.written = make(map[string]string)
+	x.staged = make(map[string]string)
+
+	var r io.Reader = src
+	rs, ok := src.(io.ReadSeeker)
+	if !ok {
+		spool, err := os.CreateTemp(staging, "bottle-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create spool file: %w", err)
+		}
+		defer spool.Close()
+		r, rs = io.TeeReader(src, spool), spool
+	}
+
+	for passes := 1; ; passes++ {
+		x.seen = make(map[string]bool)
+		x.dirs = make(map[string][]string)
+		if err := x.pass(r); err != nil {
+			return nil, err
+		}
+		if len(x.wanted) == 0 {
+			break
+		}
+		var missing []string
+		for target := range x.wanted {
+			if !x.seen[target] {
+				missing = append(missing, target)
+			}
+		}
+		if len(missing) > 0 {
+			slices.Sort(missing)
+			return nil, fmt.Errorf("link target not in bottle: %s", strings.Join(missing, ", "))
+		}
+		if passes == maxLinkPasses {
+			return nil, fmt.Errorf("too many levels of links in bottle")
+		}
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to rewind bottle: %w", err)
+		}
+		r = rs
+		// later passes only resolve links
+		x.Patterns = nil
+	}
+
+	if x.File != "" && len(x.staged) > 1 {
+		return nil, fmt.Errorf("%d files match but %s is not a directory (end it with %c to write into one)",
+			len(x.staged), x.File, filepath.Separator)
+	}
+	var paths []string
+	for dst, tmp := range x.staged {
+		if x.File != "" {
+			dst = x.File
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create dir: %w", err)
+		}
+		if err := copyFile(tmp, dst); err != nil {
+			return nil, err
+		}
+		paths = append(paths, dst)
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// copyFile replaces dst with a copy of src, keeping its mode.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fi.Mode())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", dst, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", dst, err)
+	}
+	return os.Rename(tmp, dst)
+}
+
+// extractCmd represents the extract command
+var extractCmd = &cobra.Command{
+	Use:   "extract <formula|bottle.tar.gz> [path-in-bottle]...",
+	Short: "Extract single files from a bottle",
+	Long: `Extract single files from a bottle without pouring the keg.
+
+Paths are relative to the keg (e.g. bin/jq) and may be globs or directories.
+Symlinks and hardlinks are written as copies of the files they point at, and
+a symlink to a directory as a directory of copies.
+
+Files are written under --output when it is an existing directory or ends
+with a separator. Otherwise it is the path the single selected file is
+written to.`,
+	Args:          cobra.MinimumNArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tag, _ := cmd.Flags().GetString("tag")
+		out, _ := cmd.Flags().GetString("output")
+		only, _ := cmd.Flags().GetStringSlice("only")
+		flatten, _ := cmd.Flags().GetBool("flatten")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+
+		patterns := append(args[1:], only...)
+		if len(patterns) == 0 {
+			return fmt.Errorf("nothing to extract: give a path in the bottle or --only <glob>")
+		}
+		isDir := strings.HasSuffix(out, "/") || strings.HasSuffix(out, string(filepath.Separator))
+		if fi, err := os.Stat(out); err == nil && fi.IsDir() {
+			isDir = true
+		}
+		out, err := filepath.Abs(out)
+		if err != nil {
+			return fmt.Errorf("failed to resolve output: %w", err)
+		}
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+
+		src, err := openBottleSource(ctx, args[0], tag)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		x := &extractor{Out: out, Flatten: flatten, Patterns: patterns}
+		if !isDir {
+			x.Out, x.File = filepath.Dir(out), out
+		}
+		paths, err := x.extract(src)
+		if err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("no entries in the bottle match %s", strings.Join(patterns, ", "))
+		}
+		if !quiet {
+			for _, p := range paths {
+				logger.Info("Extracted", "path", p)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(extractCmd)
+	extractCmd.Flags().StringP("tag", "t", "", "Bottle tag to extract from (defaults to the host)")
+	extractCmd.Flags().StringP("output", "o", ".", "Directory to write the files to, or file path for a single file")
+	extractCmd.Flags().StringSlice("only", nil, "Glob of keg relative paths to extract (repeatable)")
+	extractCmd.Flags().BoolP("flatten", "f", false, "Write files by their base name, dropping directories")
+	extractCmd.Flags().BoolP("quiet", "q", false, "Do not log extracted files")
+}
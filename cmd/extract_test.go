@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExtractLinks(t *testing.T) {
+	// the links come after their targets, so a second pass over a stream
+	// that can't seek is needed
+	data := testBottle(t,
+		tar.Header{Name: "tool/1.0/libexec/bin/", Typeflag: tar.TypeDir, Mode: 0o755},
+		tar.Header{Name: "tool/1.0/libexec/bin/tool", Mode: 0o755, Size: 4},
+		tar.Header{Name: "tool/1.0/libexec/lib/libtool.so.1", Size: 4},
+		tar.Header{Name: "tool/1.0/bin", Linkname: "libexec/bin"},
+		tar.Header{Name: "tool/1.0/lib", Linkname: "libexec/lib"},
+	)
+	tests := []struct {
+		name     string
+		patterns []string
+		flatten  bool
+		want     []string
+	}{
+		{"dir symlink", []string{"bin"}, false, []string{"bin/tool"}},
+		{"implicit dir symlink", []string{"lib"}, false, []string{"lib/libtool.so.1"}},
+		{"glob", []string{"*"}, false, []string{"bin/tool", "lib/libtool.so.1", "libexec/bin/tool", "libexec/lib/libtool.so.1"}},
+		{"flatten", []string{"bin", "lib"}, true, []string{"libtool.so.1", "tool"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := t.TempDir()
+			x := &extractor{Out: out, Flatten: tt.flatten, Patterns: tt.patterns}
+			paths, err := x.extract(io.NopCloser(bytes.NewReader(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range paths {
+				rel, _ := filepath.Rel(out, p)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("extracted %v, want %v", got, tt.want)
+			}
+			for _, p := range paths {
+				if fi, err := os.Lstat(p); err != nil || !fi.Mode().IsRegular() {
+					t.Errorf("%s is not a regular file", p)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractFile(t *testing.T) {
+	data := testBottle(t,
+		tar.Header{Name: "tool/1.0/bin/tool", Mode: 0o755, Size: 4},
+		tar.Header{Name: "tool/1.0/bin/tool-helper", Mode: 0o755, Size: 4},
+	)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "usr", "local", "bin", "renamed")
+	x := &extractor{Out: filepath.Dir(file), File: file, Patterns: []string{"bin/tool"}}
+	paths, err := x.extract(io.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(paths, []string{file}) {
+		t.Errorf("extracted %v, want %v", paths, []string{file})
+	}
+	if fi, err := os.Stat(file); err != nil || fi.Mode().Perm() != 0o755 {
+		t.Errorf("%s not written with its mode: %v", file, err)
+	}
+
+	x = &extractor{Out: filepath.Dir(file), File: file, Patterns: []string{"bin/*"}}
+	if _, err := x.extract(io.NopCloser(bytes.NewReader(data))); err == nil {
+		t.Error("extracting several files to a file path succeeded")
+	}
+}
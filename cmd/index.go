@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	formulaIndexAPI     = "https://formulae.brew.sh/api/formula.json"
+	formulaIndexFile    = "formula.json"
+	formulaIndexJWSAPI  = "https://formulae.brew.sh/api/formula.jws.json"
+	formulaIndexJWSFile = "formula.jws.json"
+	formulaIndexTTL     = 24 * time.Hour
+)
+
+// cacheDir returns (and creates) the bottle-bomb cache directory.
+func cacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user cache dir: %w", err)
+	}
+	dir = filepath.Join(dir, "bottle-bomb")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create cache dir: %w", err)
+	}
+	return dir, nil
+}
+
+// getFormulaIndex returns every formula in homebrew/core. The index is cached
+// for a day; a stale copy is used if it can't be refreshed. With signedAPI the
+// JWS form is fetched and its signature checked every time it is loaded.
+func getFormulaIndex(ctx context.Context) ([]Formula, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+	url, path := formulaIndexAPI, filepath.Join(dir, formulaIndexFile)
+	signed := signedAPI()
+	if signed {
+		if err := checkAPIKeys(); err != nil {
+			return nil, err
+		}
+		url, path = formulaIndexJWSAPI, filepath.Join(dir, formulaIndexJWSFile)
+	}
+
+	fi, statErr := os.Stat(path)
+	if statErr != nil || time.Since(fi.ModTime()) > formulaIndexTTL {
+		if err := downloadFormulaIndex(ctx, url, path, signed); err != nil {
+			if statErr != nil {
+				return nil, err
+			}
+			logger.Warn("Using stale formula index", "err", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read formula index: %w", err)
+	}
+	if signed {
+		if data, err = verifyJWS(data); err != nil {
+			return nil, fmt.Errorf("formula index %s: %w", path, err)
+		}
+	}
+	return decodeFormulaIndex(data)
+}
+
+// decodeFormulaIndex decodes each formula of the index on its own, so one
+// whose fields don't match our types is skipped instead of losing them all.
+func decodeFormulaIndex(data []byte) ([]Formula, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal formula index: %w", err)
+	}
+	formulae := make([]Formula, 0, len(raw))
+	for i, r := range raw {
+		var f Formula
+		if err := json.Unmarshal(r, &f); err != nil {
+			var named struct {
+				Name string `json:"name"`
+			}
+			json.Unmarshal(r, &named)
+			logger.Warn("Skipping formula in index", "index", i, "formula", named.Name, "err", err)
+			continue
+		}
+		formulae = append(formulae, f)
+	}
+	return formulae, nil
+}
+
+// downloadFormulaIndex saves url to path. A signed index that doesn't verify
+// is discarded, keeping the previous copy.
+func downloadFormulaIndex(ctx context.Context, url, path string, signed bool) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: failed to http GET: %w", errNetwork, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %s", errNetwork, url, resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%w: failed to download formula index: %w", errNetwork, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write formula index: %w", err)
+	}
+	if signed {
+		data, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			return fmt.Errorf("failed to read formula index: %w", err)
+		}
+		if _, err := verifyJWS(data); err != nil {
+			return fmt.Errorf("refusing formula index from %s: %w", url, err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save formula index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// kegReference matches a library or rpath inside another formula's keg.
+var kegReference = regexp.MustCompile(`@@HOMEBREW_(?:PREFIX@@/opt|CELLAR@@)/([^/]+)/`)
+
+// bottleFileTag matches the tag of a bottle file name, e.g.
+// jq--1.7.1.arm64_sonoma.bottle.tar.gz or jq--1.7.1.arm64_sonoma.bottle.1.tar.gz.
+var bottleFileTag = regexp.MustCompile(`\.([a-z0-9_]+)\.bottle(?:\.\d+)?\.tar\.gz$`)
+
+// inspectBinary is a Mach-O or ELF file of a bottle.
+type inspectBinary struct {
+	Path string `json:"path"`
+	*binaryInfo
+	Formulae []string `json:"formulae,omitempty"`
+}
+
+// inspectReport is what `inspect` prints.
+type inspectReport struct {
+	Formula    string          `json:"formula"`
+	Tag        string          `json:"tag,omitempty"`
+	Binaries   []inspectBinary `json:"binaries"`
+	Formulae   []string        `json:"formulae"`             // formulae the binaries link into
+	Undeclared []string        `json:"undeclared,omitempty"` // ... that aren't runtime dependencies
+	Missing    []string        `json:"missing,omitempty"`    // ... that are neither cached nor installed
+	Glibc      string          `json:"glibc,omitempty"`      // newest glibc version needed
+	HostGlibc  string          `json:"host_glibc,omitempty"`
+}
+
+// linkedFormulae returns the formulae other than self whose kegs a binary
+// references in its libraries or rpaths.
+func (b *binaryInfo) linkedFormulae(self string) []string {
+	var names []string
+	for _, p := range slices.Concat(b.Libraries, b.RPaths) {
+		for _, m := range kegReference.FindAllStringSubmatch(p, -1) {
+			if m[1] != self {
+				names = append(names, m[1])
+			}
+		}
+	}
+	return sortedUnique(names)
+}
+
+// hostGlibc returns the glibc version of the running Linux host, or "".
+func hostGlibc() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	out, err := exec.Command("getconf", "GNU_LIBC_VERSION").Output()
+	if err != nil {
+		return ""
+	}
+	// glibc 2.39
+	if fields := strings.Fields(string(out)); len(fields) == 2 {
+		return fields[1]
+	}
+	return ""
+}
+
+// inspectSource opens the bottle to inspect and returns the formula it belongs
+// to. For a local file the formula and tag come from its name, and formula
+// is nil if it can't be looked up.
+func inspectSource(ctx context.Context, arg, tag string) (io.ReadCloser, *Formula, string, string, error) {
+	if fi, err := os.Stat(arg); err == nil && !fi.IsDir() {
+		f, err := os.Open(arg)
+		if err != nil {
+			return nil, nil, "", "", fmt.Errorf("failed to open bottle: %w", err)
+		}
+		base := filepath.Base(arg)
+		name, _, ok := strings.Cut(base, "--")
+		if !ok {
+			name = strings.TrimSuffix(base, ".tar.gz")
+		}
+		if m := bottleFileTag.FindStringSubmatch(base); m != nil && tag == "" {
+			tag = m[1]
+		}
+		formula, err := getFormula(ctx, name)
+		if err != nil {
+			logger.Warn("Not checking dependencies", "formula", name, "err", err)
+			formula = nil
+		}
+		return f, formula, name, tag, nil
+	}
+
+	formula, err := getFormula(ctx, arg)
+	if err != nil {
+		return nil, nil, "", "", fmt.Errorf("failed to get formula '%s': %w", arg, err)
+	}
+	bottle, err := formula.BottleFor(tag)
+	if err != nil {
+		return nil, nil, "", "", err
+	}
+	r, err := openFormulaBottle(ctx, formula, bottle)
+	if err != nil {
+		return nil, nil, "", "", err
+	}
+	return r, formula, formula.Name, bottle.Tag, nil
+}
+
+// checkClosure records the linked formulae that are not runtime dependencies
+// for the tag, and those that are neither in the download cache nor poured
+// into prefix.
+func (r *inspectReport) checkClosure(ctx context.Context, formula *Formula, prefix string) error {
+	plan, err := planDependencies(ctx, formula, r.Tag)
+	if err != nil {
+		return fmt.Errorf("failed to resolve dependencies: %w", err)
+	}
+	nodes := make(map[string]*depNode)
+	for _, n := range plan.Dependencies() {
+		nodes[n.Formula.Name] = n
+	}
+	for _, name := range r.Formulae {
+		n, ok := nodes[name]
+		if !ok {
+			r.Undeclared = append(r.Undeclared, name)
+			continue
+		}
+		if prefix != "" {
+			if keg, err := findKeg(prefix, name); err == nil && keg.Version == n.Formula.pkgVersion() {
+				continue
+			}
+		}
+		if n.Bottle != nil {
+			if dst, err := downloadPath(n.Formula, n.Bottle, true); err == nil && cachedBottle(n.Formula, n.Bottle, dst) != nil {
+				continue
+			}
+		}
+		r.Missing = append(r.Missing, name)
+	}
+	return nil
+}
+
+// inspectCmd represents the inspect command
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <formula|bottle.tar.gz>",
+	Short: "List the libraries a bottle's binaries link against",
+	Long: `List the libraries a bottle's binaries link against.
+
+Libraries and rpaths under @@HOMEBREW_PREFIX@@/opt/<formula> are mapped back
+to formulae, and a warning is logged for those that are not runtime
+dependencies or that are neither in the download cache nor poured into
+--prefix. ELF binaries needing a newer glibc than the host (or --glibc) are
+reported too.`,
+	Args:          cobra.ExactArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tag, _ := cmd.Flags().GetString("tag")
+		glibc, _ := cmd.Flags().GetString("glibc")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+
+		src, formula, name, tag, err := inspectSource(ctx, args[0], tag)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		entries, err := snapshotBottle(src)
+		if err != nil {
+			return err
+		}
+
+		report := &inspectReport{Formula: name, Tag: tag, Binaries: []inspectBinary{}, Formulae: []string{}}
+		for _, e := range entries {
+			if e.Binary == nil {
+				continue
+			}
+			b := inspectBinary{Path: e.Path, binaryInfo: e.Binary, Formulae: e.Binary.linkedFormulae(name)}
+			report.Binaries = append(report.Binaries, b)
+			report.Formulae = append(report.Formulae, b.Formulae...)
+			if compareVersions(e.Binary.Glibc, report.Glibc) > 0 {
+				report.Glibc = e.Binary.Glibc
+			}
+		}
+		slices.SortFunc(report.Binaries, func(a, b inspectBinary) int { return strings.Compare(a.Path, b.Path) })
+		report.Formulae = sortedUnique(report.Formulae)
+
+		if formula != nil && report.Tag != "" {
+			prefix, _ := prefixFlag(cmd)
+			if err := report.checkClosure(ctx, formula, prefix); err != nil {
+				logger.Warn("Not checking dependencies", "formula", name, "err", err)
+			}
+		}
+		if glibc == "" {
+			glibc = hostGlibc()
+		}
+		report.HostGlibc = glibc
+
+		if asJSON {
+			return printJSON(report)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, b := range report.Binaries {
+			fmt.Fprintf(w, "%s\t%s %s\n", b.Path, b.Format, strings.Join(b.Arch, ","))
+			for _, lib := range b.Libraries {
+				fmt.Fprintf(w, "\t  %s\n", lib)
+			}
+		}
+		w.Flush()
+		if len(report.Binaries) == 0 {
+			logger.Info("No Mach-O or ELF binaries", "formula", name)
+		}
+		if len(report.Formulae) > 0 {
+			logger.Info("Links against", "formulae", strings.Join(report.Formulae, ", "))
+		}
+		for _, dep := range report.Undeclared {
+			logger.Warn("Links against a formula that is not a runtime dependency", "formula", dep, "tag", report.Tag)
+		}
+		for _, dep := range report.Missing {
+			logger.Warn("Linked formula is not downloaded or installed", "formula", dep)
+		}
+		if report.Glibc != "" {
+			switch {
+			case glibc == "":
+				logger.Info("Needs glibc", "version", report.Glibc)
+			case compareVersions(report.Glibc, glibc) > 0:
+				for _, b := range report.Binaries {
+					if compareVersions(b.Glibc, glibc) > 0 {
+						logger.Warn("Needs a newer glibc than the host", "binary", b.Path, "glibc", b.Glibc, "host", glibc)
+					}
+				}
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+	inspectCmd.Flags().StringP("tag", "t", "", "Bottle tag to inspect (defaults to the host)")
+	inspectCmd.Flags().String("glibc", "", "glibc version of the target host (defaults to the running host's)")
+	inspectCmd.Flags().Bool("json", false, "Print the report as JSON")
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// embeddedKeys holds the API signing keys built into the binary, see
+// keys/README.md.
+//
+//go:generate curl -fsSL -o keys/homebrew-1.pem https://raw.githubusercontent.com/Homebrew/brew/master/Library/Homebrew/api/homebrew-1.pem
+//go:embed keys
+var embeddedKeys embed.FS
+
+var (
+	verifyAPI  bool   // --verify-api, on by default when a key is embedded
+	apiKeyPath string // --api-key, overrides the embedded keys
+)
+
+// jwsDocument is a JWS JSON serialization as served by the formulae API.
+// Homebrew signs the unencoded payload (b64: false).
+type jwsDocument struct {
+	Payload    string `json:"payload"`
+	Signatures []struct {
+		Protected string `json:"protected"`
+		Header    struct {
+			KeyID string `json:"kid"`
+		} `json:"header"`
+		Signature string `json:"signature"`
+	} `json:"signatures"`
+}
+
+// apiKeyFile returns the key given with --api-key (or $BOTTLE_BOMB_API_KEY).
+func apiKeyFile() string {
+	if apiKeyPath != "" {
+		return apiKeyPath
+	}
+	return os.Getenv("BOTTLE_BOMB_API_KEY")
+}
+
+// signedAPI reports whether the formulae API should be fetched in its signed
+// form: with --verify-api (the default when a key is embedded), or when
+// --api-key is given.
+func signedAPI() bool {
+	return verifyAPI || apiKeyFile() != ""
+}
+
+// hasEmbeddedKeys reports whether the binary was built with the keys in
+// cmd/keys. A plain `go build` without `go generate` has none.
+func hasEmbeddedKeys() bool {
+	keys, _ := fs.Glob(embeddedKeys, "keys/*.pem")
+	return len(keys) > 0
+}
+
+// checkAPIKeys fails when the signed API is used without a key to check it
+// with, rather than after downloading the index.
+func checkAPIKeys() error {
+	if apiKeyFile() != "" || hasEmbeddedKeys() {
+		return nil
+	}
+	return fmt.Errorf("%w: no key to check the signed formulae API with; build with the keys in cmd/keys (go generate ./cmd), pass --api-key, or use --verify-api=false", errVerify)
+}
+
+// apiPublicKey returns the key for kid: the --api-key file if given,
+// otherwise the embedded keys/<kid>.pem.
+func apiPublicKey(kid string) (*rsa.PublicKey, error) {
+	var data []byte
+	var err error
+	if file := apiKeyFile(); file != "" {
+		data, err = os.ReadFile(file)
+	} else {
+		data, err = embeddedKeys.ReadFile(path.Join("keys", path.Base(kid)+".pem"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no public key for '%s': %w", kid, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("public key for '%s' is not PEM encoded", kid)
+	}
+	var key any
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key for '%s': %w", kid, err)
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key for '%s' is not an RSA key", kid)
+	}
+	return pub, nil
+}
+
+// verifyJWS checks that data is a JWS signed with PS512 by a known key and
+// returns its payload.
+func verifyJWS(data []byte) ([]byte, error) {
+	var doc jwsDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse JWS: %w", errVerify, err)
+	}
+	if len(doc.Signatures) == 0 {
+		return nil, fmt.Errorf("%w: JWS has no signatures", errVerify)
+	}
+
+	var errs []error
+	for _, sig := range doc.Signatures {
+		if err := verifyJWSSignature(doc.Payload, sig.Protected, sig.Header.KeyID, sig.Signature); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return []byte(doc.Payload), nil
+	}
+	return nil, fmt.Errorf("%w: %w", errVerify, errors.Join(errs...))
+}
+
+func verifyJWSSignature(payload, protected, kid, signature string) error {
+	header, err := base64.RawURLEncoding.DecodeString(protected)
+	if err != nil {
+		return fmt.Errorf("invalid protected header: %w", err)
+	}
+	var params struct {
+		Alg string `json:"alg"`
+		B64 *bool  `json:"b64"`
+	}
+	if err := json.Unmarshal(header, &params); err != nil {
+		return fmt.Errorf("invalid protected header: %w", err)
+	}
+	if params.Alg != "PS512" {
+		return fmt.Errorf("unsupported algorithm '%s'", params.Alg)
+	}
+	if params.B64 == nil || *params.B64 {
+		return errors.New("expected an unencoded (b64: false) payload")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	pub, err := apiPublicKey(kid)
+	if err != nil {
+		return err
+	}
+	digest := sha512.Sum512([]byte(protected + "." + payload))
+	if err := rsa.VerifyPSS(pub, crypto.SHA512, digest[:], sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+		return fmt.Errorf("bad signature from '%s'", kid)
+	}
+	return nil
+}
@@ -0,0 +1,455 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"debug/elf"
+	"debug/macho"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Keg is a poured formula version, i.e. <prefix>/Cellar/<name>/<version>.
+type Keg struct {
+	Prefix  string
+	Name    string
+	Version string
+}
+
+// Path is the keg directory.
+func (k *Keg) Path() string {
+	return filepath.Join(k.Prefix, "Cellar", k.Name, k.Version)
+}
+
+// OptPath is the version independent <prefix>/opt/<name> link.
+func (k *Keg) OptPath() string {
+	return filepath.Join(k.Prefix, "opt", k.Name)
+}
+
+// prefixFlag returns the absolute --prefix, falling back to $HOMEBREW_PREFIX.
+func prefixFlag(cmd *cobra.Command) (string, error) {
+	prefix, _ := cmd.Flags().GetString("prefix")
+	if prefix == "" {
+		prefix = os.Getenv("HOMEBREW_PREFIX")
+	}
+	if prefix == "" {
+		return "", fmt.Errorf("no prefix given: pass --prefix or set HOMEBREW_PREFIX")
+	}
+	return filepath.Abs(prefix)
+}
+
+// findKeg returns the most recently poured keg of the formula in prefix.
+func findKeg(prefix, name string) (*Keg, error) {
+	entries, err := os.ReadDir(filepath.Join(prefix, "Cellar", name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("'%s' is not installed in %s", name, prefix)
+		}
+		return nil, fmt.Errorf("failed to read Cellar: %w", err)
+	}
+	var kegs []os.FileInfo
+	for _, e := range entries {
+		if fi, err := e.Info(); err == nil && e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			kegs = append(kegs, fi)
+		}
+	}
+	if len(kegs) == 0 {
+		return nil, fmt.Errorf("'%s' is not installed in %s", name, prefix)
+	}
+	sort.Slice(kegs, func(i, j int) bool { return kegs[i].ModTime().After(kegs[j].ModTime()) })
+	return &Keg{Prefix: prefix, Name: name, Version: kegs[0].Name()}, nil
+}
+
+// installBottle pours a downloaded bottle into prefix, links it and records
+// what it created in the prefix's install database.
+func installBottle(formula *Formula, res *downloadResult, index *Bottle, prefix string, onRequest bool, opts linkOptions) (*Keg, error) {
+	bottle, err := formula.BottleFor(res.Tag)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openInstallDB(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keg, poured, err := pourBottle(res.Path, prefix, formula, bottle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pour bottle: %w", err)
+	}
+
+	rec := &installRecord{
+		Name:               keg.Name,
+		Version:            keg.Version,
+		Tag:                bottle.Tag,
+		Rebuild:            formula.Bottle.Stable.Rebuild,
+		Dependencies:       formula.Dependencies,
+		InstalledOnRequest: onRequest,
+		InstalledAt:        time.Now(),
+	}
+	if poured {
+		if err := writeReceipt(keg, formula, index.Manifest(bottle.Tag), onRequest); err != nil {
+			return keg, err
+		}
+		files, err := kegFiles(keg)
+		if err != nil {
+			return keg, err
+		}
+		rec.Files = db.rel(files)
+	} else {
+		for _, old := range db.get(keg.Name) {
+			if old.Version == keg.Version {
+				rec.Files, rec.Links = old.Files, old.Links
+				rec.InstalledOnRequest = old.InstalledOnRequest || onRequest
+				rec.InstalledAt = old.InstalledAt
+			}
+		}
+	}
+
+	links, err := linkKeg(keg, formula, opts)
+	rec.Links = mergeLinks(rec.Links, db.rel(links))
+	db.put(rec)
+	if serr := db.save(); serr != nil && err == nil {
+		err = serr
+	}
+	if err != nil {
+		return keg, err
+	}
+	logger.Info("Installed", "keg", keg.Path(), "links", len(links))
+	return keg, nil
+}
+
+// mergeLinks appends the links in b missing from a.
+func mergeLinks(a, b []string) []string {
+	for _, l := range b {
+		if !slices.Contains(a, l) {
+			a = append(a, l)
+		}
+	}
+	return a
+}
+
+// pourBottle extracts the bottle tarball at path into <prefix>/Cellar and
+// relocates the Homebrew placeholders to prefix. An already poured keg is
+// left untouched and poured is false.
+func pourBottle(path, prefix string, formula *Formula, bottle *BottleFile) (keg *Keg, poured bool, err error) {
+	return pourBottleAs(path, prefix, prefix, formula, bottle)
+}
+
+// pourBottleAs is pourBottle for a prefix staged in dir, e.g. an image's
+// rootfs: the keg is extracted into <dir>/Cellar but relocated to prefix.
+func pourBottleAs(path, dir, prefix string, formula *Formula, bottle *BottleFile) (keg *Keg, poured bool, err error) {
+	keg = &Keg{Prefix: dir, Name: formula.Name, Version: formula.pkgVersion()}
+
+	cellar := filepath.Join(dir, "Cellar")
+	switch bottle.Cellar {
+	case ":any", ":any_skip_relocation", "":
+	default:
+		if filepath.Clean(bottle.Cellar) != filepath.Join(prefix, "Cellar") {
+			return nil, false, fmt.Errorf("bottle for '%s' only works with Cellar %s (prefix %s)", bottle.Tag, bottle.Cellar, filepath.Dir(bottle.Cellar))
+		}
+	}
+
+	if _, err := os.Stat(keg.Path()); err == nil {
+		logger.Info("Already poured", "keg", keg.Path())
+		return keg, false, nil
+	}
+
+	findings, err := scanBottleFile(path)
+	for _, f := range findings {
+		logger.Error("Unsafe bottle entry", "path", f.Path, "issue", f.Issue, "detail", f.Detail)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := os.MkdirAll(filepath.Join(cellar, formula.Name), 0o755); err != nil {
+		return nil, false, fmt.Errorf("failed to create Cellar: %w", err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Join(cellar, formula.Name), ".pour-")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open bottle: %w", err)
+	}
+	defer f.Close()
+
+	r := &relocator{
+		skip: bottle.Cellar == ":any_skip_relocation",
+		replacements: []string{
+			"@@HOMEBREW_PREFIX@@", prefix,
+			"@@HOMEBREW_CELLAR@@", filepath.Join(prefix, "Cellar"),
+			"@@HOMEBREW_REPOSITORY@@", prefix,
+			"@@HOMEBREW_LIBRARY@@", filepath.Join(prefix, "Library"),
+			"@@HOMEBREW_PERL@@", "/usr/bin/perl",
+			"@@HOMEBREW_JAVA@@", filepath.Join(prefix, "opt", "openjdk", "libexec"),
+		},
+	}
+	if err := extractBottle(f, tmp, r); err != nil {
+		return nil, false, err
+	}
+
+	extracted := filepath.Join(tmp, formula.Name, keg.Version)
+	if _, err := os.Stat(extracted); err != nil {
+		return nil, false, fmt.Errorf("bottle does not contain %s/%s", formula.Name, keg.Version)
+	}
+	if err := os.Rename(extracted, keg.Path()); err != nil {
+		return nil, false, fmt.Errorf("failed to move keg into Cellar: %w", err)
+	}
+	return keg, true, nil
+}
+
+// extractBottle extracts a gzipped bottle tarball into dir.
+func extractBottle(r io.Reader, dir string, rel *relocator) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read gzip: %w", err)
+	}
+	defer gz.Close()
+
+	links := make(bottleLinks)
+	files := make(map[string]bool) // regular files extracted so far
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar: %w", err)
+		}
+
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("refusing to extract '%s' outside of the Cellar", hdr.Name)
+		}
+		// nothing may be written through a symlink the bottle created itself
+		if via, ok := links.via(filepath.ToSlash(name)); ok {
+			return fmt.Errorf("%w: refusing to extract '%s' through symlink '%s'", errUnsafe, hdr.Name, via)
+		}
+		target := filepath.Join(dir, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return fmt.Errorf("failed to create dir: %w", err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return fmt.Errorf("failed to create dir: %w", err)
+			}
+			if err := rel.writeFile(target, tr, hdr.FileInfo().Mode().Perm()|0o200); err != nil {
+				return fmt.Errorf("failed to extract '%s': %w", hdr.Name, err)
+			}
+			files[filepath.ToSlash(name)] = true
+			if err := os.Chmod(target, hdr.FileInfo().Mode().Perm()); err != nil {
+				return fmt.Errorf("failed to chmod '%s': %w", hdr.Name, err)
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return fmt.Errorf("failed to create dir: %w", err)
+			}
+			link := filepath.ToSlash(name)
+			dst, ok := links.resolve(path.Dir(link) + "/" + hdr.Linkname)
+			if path.IsAbs(hdr.Linkname) || !ok || !within(dst, kegDir(link)) {
+				return fmt.Errorf("%w: refusing symlink '%s' -> '%s' outside its keg", errUnsafe, hdr.Name, hdr.Linkname)
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return fmt.Errorf("failed to create symlink: %w", err)
+			}
+			links[link] = hdr.Linkname
+		case tar.TypeLink:
+			old := path.Clean(hdr.Linkname)
+			if path.IsAbs(old) || !within(old, kegDir(filepath.ToSlash(name))) || !files[old] {
+				return fmt.Errorf("%w: refusing hardlink '%s' => '%s' to a file outside its keg", errUnsafe, hdr.Name, hdr.Linkname)
+			}
+			if via, ok := links.via(old); ok {
+				return fmt.Errorf("%w: refusing to hardlink '%s' through symlink '%s'", errUnsafe, hdr.Name, via)
+			}
+			if err := os.Link(filepath.Join(dir, filepath.Clean(hdr.Linkname)), target); err != nil {
+				return fmt.Errorf("failed to create hardlink: %w", err)
+			}
+		default:
+			logger.Warn("Skipping unsupported tar entry", "name", hdr.Name, "type", string(hdr.Typeflag))
+		}
+	}
+}
+
+// relocator replaces the Homebrew placeholders left in bottles at build time.
+type relocator struct {
+	skip         bool
+	replacements []string // old, new pairs
+}
+
+func (r *relocator) relocate(data []byte) ([]byte, error) {
+	if r == nil || r.skip || !bytes.Contains(data, placeholderPrefix) {
+		return data, nil
+	}
+	if bytes.IndexByte(data, 0) < 0 {
+		return []byte(strings.NewReplacer(r.replacements...).Replace(string(data))), nil
+	}
+	return r.relocateBinary(data)
+}
+
+// placeholderPrefix starts every path placeholder in a bottle.
+var placeholderPrefix = []byte("@@HOMEBREW_")
+
+// placeholderScanner is a writer that notes whether placeholderPrefix went
+// through it, even when split across writes.
+type placeholderScanner struct {
+	tail  []byte // the last bytes written, in case the prefix straddles writes
+	found bool
+}
+
+func (s *placeholderScanner) Write(p []byte) (int, error) {
+	if s.found {
+		return len(p), nil
+	}
+	n := len(placeholderPrefix) - 1
+	edge := append(s.tail, p[:min(len(p), n)]...)
+	s.found = bytes.Contains(edge, placeholderPrefix) || bytes.Contains(p, placeholderPrefix)
+	if len(p) >= n {
+		s.tail = append(s.tail[:0], p[len(p)-n:]...)
+	} else {
+		s.tail = append(s.tail[:0], edge[max(0, len(edge)-n):]...)
+	}
+	return len(p), nil
+}
+
+// writeFile streams a bottle file to target and then relocates it if it held
+// a placeholder, so only files that need it are read into memory.
+func (r *relocator) writeFile(target string, src io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	var scan placeholderScanner
+	if _, err := io.Copy(io.MultiWriter(f, &scan), src); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if r == nil || r.skip || !scan.found {
+		return nil
+	}
+
+	orig, err := os.ReadFile(target)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	data, err := r.relocate(orig)
+	if err != nil {
+		return fmt.Errorf("failed to relocate: %w", err)
+	}
+	if bytes.Equal(orig, data) {
+		return nil
+	}
+	if err := os.WriteFile(target, data, perm); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if isMachO(data) {
+		if err := resignMachO(target); err != nil {
+			return fmt.Errorf("failed to relocate: %w", err)
+		}
+	}
+	return nil
+}
+
+// relocateBinary rewrites placeholders inside NUL terminated strings in place,
+// padding with NULs so no offsets move. ELF dynamic strings that grow are
+// moved instead (see relocateELF); anything else that doesn't fit fails.
+func (r *relocator) relocateBinary(data []byte) ([]byte, error) {
+	out, long := r.replaceInPlace(data)
+	switch {
+	case len(long) == 0:
+		return out, nil
+	case bytes.HasPrefix(data, []byte(elf.ELFMAG)):
+		return r.relocateELF(data, out, long)
+	}
+	return nil, long[0].err()
+}
+
+// longString is a string in a binary whose relocated form doesn't fit in
+// place; data[start:end] runs from the placeholder to the NUL.
+type longString struct {
+	start, end int
+	old, new   string
+}
+
+func (l longString) err() error {
+	return fmt.Errorf("'%s' is too long to replace %s in a binary (try a shorter prefix)", l.new, l.old)
+}
+
+// replaceInPlace does the in-place rewrite, leaving the strings that don't
+// fit untouched and returning them.
+func (r *relocator) replaceInPlace(data []byte) ([]byte, []longString) {
+	out := bytes.Clone(data)
+	var long []longString
+	for i := 0; i < len(r.replacements); i += 2 {
+		old := []byte(r.replacements[i])
+		for off := 0; ; {
+			idx := bytes.Index(out[off:], old)
+			if idx < 0 {
+				break
+			}
+			start := off + idx
+			end := bytes.IndexByte(out[start:], 0)
+			if end < 0 {
+				end = len(out) - start
+			}
+			end += start
+			off = end
+			str := bytes.ReplaceAll(out[start:end], old, []byte(r.replacements[i+1]))
+			if len(str) > end-start {
+				long = append(long, longString{start: start, end: end, old: r.replacements[i], new: r.replacements[i+1]})
+				continue
+			}
+			copy(out[start:end], str)
+			clear(out[start+len(str) : end])
+		}
+	}
+	return out, long
+}
+
+// isMachO reports whether data is a thin or universal Mach-O file.
+// The universal magic is also that of Java class files, so it is parsed.
+func isMachO(data []byte) bool {
+	if !isBinary(data) || bytes.HasPrefix(data, []byte(elf.ELFMAG)) {
+		return false
+	}
+	if _, err := macho.NewFatFile(bytes.NewReader(data)); err == nil {
+		return true
+	}
+	_, err := macho.NewFile(bytes.NewReader(data))
+	return err == nil
+}
+
+// resignMachO ad-hoc signs a Mach-O file whose placeholders were rewritten:
+// the edit invalidates its signature and macOS on arm64 kills unsigned code.
+// There's no codesign elsewhere, so it refuses instead of leaving it broken.
+func resignMachO(path string) error {
+	if runtime.GOOS != "darwin" {
+		return fmt.Errorf("can't re-sign a relocated Mach-O file on %s", runtime.GOOS)
+	}
+	if out, err := exec.Command("codesign", "--force", "--sign", "-", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to codesign: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
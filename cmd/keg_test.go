@@ -0,0 +1,320 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// machO64 is a thin x86_64 Mach-O header without load commands.
+func machO64() []byte {
+	var b bytes.Buffer
+	for _, v := range []uint32{0xfeedfacf, 0x01000007, 3, 2, 0, 0, 0, 0} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	return b.Bytes()
+}
+
+func TestIsMachO(t *testing.T) {
+	thin := machO64()
+	var fat bytes.Buffer
+	for _, v := range []uint32{0xcafebabe, 1, 0x01000007, 3, 64, uint32(len(thin)), 0} {
+		binary.Write(&fat, binary.BigEndian, v)
+	}
+	fat.Write(make([]byte, 64-fat.Len()))
+	fat.Write(thin)
+
+	// a Java class file: the universal magic, then minor and major version
+	class := append([]byte{0xca, 0xfe, 0xba, 0xbe, 0, 0, 0, 65, 0, 0x1d}, []byte("@@HOMEBREW_PREFIX@@\x00")...)
+
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"thin", thin, true},
+		{"universal", fat.Bytes(), true},
+		{"java class", class, false},
+		{"elf", []byte("\x7fELF\x02\x01\x01\x00"), false},
+		{"text", []byte("#!/bin/sh\n"), false},
+	}
+	for _, tt := range tests {
+		if got := isMachO(tt.data); got != tt.want {
+			t.Errorf("isMachO(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	// built from testdata/placeholder.c, see testdata/README.md
+	exe := readFixture(t, "placeholder.elf")
+	tests := []struct {
+		name   string
+		data   []byte
+		prefix string
+		err    bool
+	}{
+		{"text", []byte("#!@@HOMEBREW_PREFIX@@/bin/sh\n"), "/opt/a-rather-long-prefix-for-a-script", false},
+		{"elf in place", exe, "/opt/bb", false},
+		{"elf grown", exe, "/opt/a/prefix/that/is/much/longer/than/the/placeholder", false},
+		{"other binary too long", []byte("\x00@@HOMEBREW_PREFIX@@/lib\x00"), "/opt/a/prefix/that/is/much/longer/than/the/placeholder", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &relocator{replacements: []string{"@@HOMEBREW_PREFIX@@", tt.prefix}}
+			out, err := r.relocate(tt.data)
+			if tt.err {
+				if err == nil {
+					t.Fatal("relocated a string that doesn't fit")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a grown ELF keeps its old, now unused, string table
+			if bytes.Contains(out, placeholderPrefix) && !strings.Contains(tt.name, "grown") {
+				t.Error("placeholder left after relocation")
+			}
+			if !bytes.HasPrefix(out, []byte(elf.ELFMAG)) {
+				if want := "#!" + tt.prefix + "/bin/sh\n"; string(out) != want {
+					t.Errorf("relocated to %q, want %q", out, want)
+				}
+				return
+			}
+			f, err := elf.NewFile(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("relocated file doesn't parse: %v", err)
+			}
+			for _, p := range f.Progs {
+				if p.Type != elf.PT_INTERP {
+					continue
+				}
+				interp := make([]byte, p.Filesz)
+				if _, err := p.ReadAt(interp, 0); err != nil {
+					t.Fatal(err)
+				}
+				if got := cstring(interp); got != tt.prefix+"/lib/ld.so" {
+					t.Errorf("interpreter = %q", got)
+				}
+			}
+			runpath, err := f.DynString(elf.DT_RUNPATH)
+			if err != nil || len(runpath) != 1 || runpath[0] != tt.prefix+"/lib" {
+				t.Errorf("runpath = %v, %v", runpath, err)
+			}
+			if grown := len(out) > len(exe); grown != strings.Contains(tt.name, "grown") {
+				t.Errorf("file grew from %d to %d bytes", len(exe), len(out))
+			}
+		})
+	}
+}
+
+func TestPlaceholderScanner(t *testing.T) {
+	tests := []struct {
+		writes []string
+		want   bool
+	}{
+		{[]string{"no placeholder here"}, false},
+		{[]string{"x@@HOMEBREW_PREFIX@@y"}, true},
+		{[]string{"x@@HOME", "BREW_PREFIX@@"}, true},
+		{[]string{"@", "@", "H", "OMEBREW_"}, true},
+		{[]string{"@@HOMEBREW", "-not"}, false},
+	}
+	for _, tt := range tests {
+		var s placeholderScanner
+		for _, w := range tt.writes {
+			s.Write([]byte(w))
+		}
+		if s.found != tt.want {
+			t.Errorf("%q: found = %v, want %v", tt.writes, s.found, tt.want)
+		}
+	}
+}
+
+// testKeg pours files (keg relative, "" content for a dir) into a keg in a
+// new prefix.
+func testKeg(t *testing.T, version string, files ...string) *Keg {
+	t.Helper()
+	keg := &Keg{Prefix: t.TempDir(), Name: "tool", Version: version}
+	for _, f := range files {
+		path := filepath.Join(keg.Path(), f)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(f), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keg
+}
+
+func TestLinkKeg(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, prefix string) // what is in the prefix already
+		opts     linkOptions
+		conflict bool              // fails before linking anything
+		fails    bool              // fails part way and rolls back
+		want     map[string]string // prefix path => what it should be afterwards
+	}{
+		{"fresh", nil, linkOptions{}, false, false, map[string]string{
+			"bin/tool":                 "link:../Cellar/tool/1.0/bin/tool",
+			"share/tool/data":          "link:../../Cellar/tool/1.0/share/tool/data",
+			"opt/tool":                 "link:../Cellar/tool/1.0",
+			"var/homebrew/linked/tool": "link:../../../Cellar/tool/1.0",
+		}},
+		{"conflicting file", func(t *testing.T, prefix string) {
+			writeFile(t, filepath.Join(prefix, "bin/tool"), "mine")
+		}, linkOptions{}, true, false, map[string]string{
+			"bin/tool": "file:mine",
+			"opt/tool": "",
+		}},
+		{"overwrite", func(t *testing.T, prefix string) {
+			writeFile(t, filepath.Join(prefix, "bin/tool"), "mine")
+		}, linkOptions{Overwrite: true}, false, false, map[string]string{
+			"bin/tool": "link:../Cellar/tool/1.0/bin/tool",
+		}},
+		{"link_overwrite glob", func(t *testing.T, prefix string) {
+			writeFile(t, filepath.Join(prefix, "bin/tool"), "mine")
+		}, linkOptions{Globs: []string{"bin/*"}}, false, false, map[string]string{
+			"bin/tool": "link:../Cellar/tool/1.0/bin/tool",
+		}},
+		{"other version", func(t *testing.T, prefix string) {
+			symlink(t, "../Cellar/tool/0.9/bin/tool", filepath.Join(prefix, "bin/tool"))
+		}, linkOptions{}, false, false, map[string]string{
+			"bin/tool": "link:../Cellar/tool/1.0/bin/tool",
+		}},
+		{"other formula", func(t *testing.T, prefix string) {
+			symlink(t, "../Cellar/other/1.0/bin/tool", filepath.Join(prefix, "bin/tool"))
+		}, linkOptions{}, true, false, map[string]string{
+			"bin/tool": "link:../Cellar/other/1.0/bin/tool",
+		}},
+		{"rolled back", func(t *testing.T, prefix string) {
+			symlink(t, "../Cellar/tool/0.9/bin/tool", filepath.Join(prefix, "bin/tool"))
+			// a directory can't be replaced by a link, even with --overwrite
+			writeFile(t, filepath.Join(prefix, "share/tool/data/keep"), "mine")
+		}, linkOptions{Overwrite: true}, false, true, map[string]string{
+			"bin/tool":                 "link:../Cellar/tool/0.9/bin/tool",
+			"share/tool/data/keep":     "file:mine",
+			"opt/tool":                 "",
+			"var/homebrew/linked/tool": "",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keg := testKeg(t, "1.0", "bin/tool", "share/tool/data")
+			if tt.setup != nil {
+				tt.setup(t, keg.Prefix)
+			}
+			links, err := linkKeg(keg, nil, tt.opts)
+			var conflict *linkConflictError
+			switch {
+			case tt.conflict && !errors.As(err, &conflict):
+				t.Errorf("err = %v, want a conflict", err)
+			case tt.fails && err == nil:
+				t.Error("linked over a directory")
+			case !tt.conflict && !tt.fails && err != nil:
+				t.Errorf("err = %v", err)
+			case err != nil && len(links) > 0:
+				t.Errorf("failed but kept links %v", links)
+			}
+			for rel, want := range tt.want {
+				path := filepath.Join(keg.Prefix, rel)
+				got := ""
+				if target, err := os.Readlink(path); err == nil {
+					got = "link:" + target
+				} else if data, err := os.ReadFile(path); err == nil {
+					got = "file:" + string(data)
+				}
+				if got != want {
+					t.Errorf("%s is %q, want %q", rel, got, want)
+				}
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func symlink(t *testing.T, target, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPourBottle(t *testing.T) {
+	exe := readFixture(t, "placeholder.elf")
+	files := []struct {
+		name, content string
+		mode          int64
+	}{
+		{"tool/1.0/bin/tool", "#!@@HOMEBREW_PREFIX@@/bin/sh\n", 0o755},
+		{"tool/1.0/lib/tool.so", string(exe), 0o644},
+		{"tool/1.0/share/tool/data", strings.Repeat("no placeholder ", 10000), 0o644},
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: f.mode, Size: int64(len(f.content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(f.content))
+	}
+	tw.Close()
+	gz.Close()
+	bottle := filepath.Join(t.TempDir(), "tool--1.0.x86_64_linux.bottle.tar.gz")
+	if err := os.WriteFile(bottle, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := filepath.Join(t.TempDir(), "a", "prefix", "longer", "than", "the", "placeholder")
+	formula := &Formula{Name: "tool"}
+	formula.Versions.Stable = "1.0"
+	keg, poured, err := pourBottle(bottle, prefix, formula, &BottleFile{Tag: "x86_64_linux", Cellar: ":any"})
+	if err != nil || !poured {
+		t.Fatalf("pourBottle = %v, %v", poured, err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(keg.Path(), "bin/tool"))
+	if err != nil || string(got) != "#!"+prefix+"/bin/sh\n" {
+		t.Errorf("bin/tool = %q, %v", got, err)
+	}
+	if fi, err := os.Stat(filepath.Join(keg.Path(), "bin/tool")); err != nil || fi.Mode().Perm() != 0o755 {
+		t.Errorf("bin/tool mode = %v, %v", fi.Mode(), err)
+	}
+	f, err := elf.Open(filepath.Join(keg.Path(), "lib/tool.so"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if runpath, err := f.DynString(elf.DT_RUNPATH); err != nil || len(runpath) != 1 || runpath[0] != prefix+"/lib" {
+		t.Errorf("runpath = %v, %v", runpath, err)
+	}
+	if got, err := os.ReadFile(filepath.Join(keg.Path(), "share/tool/data")); err != nil || string(got) != files[2].content {
+		t.Errorf("share/tool/data changed: %v", err)
+	}
+
+	if _, poured, err := pourBottle(bottle, prefix, formula, &BottleFile{Tag: "x86_64_linux", Cellar: ":any"}); err != nil || poured {
+		t.Errorf("pouring again = %v, %v, want the existing keg", poured, err)
+	}
+}
@@ -0,0 +1,306 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	denyLicenses  []string // --deny-license
+	allowLicenses []string // --allow-license
+)
+
+// licenseExpr is a parsed SPDX license expression.
+type licenseExpr interface {
+	// satisfied reports whether the expression can be met using only
+	// licenses ok accepts, returning the licenses that fail otherwise.
+	satisfied(ok func(licenseID) bool) (bool, []string)
+}
+
+// licenseID is a single license, optionally with an exception.
+type licenseID struct {
+	ID        string
+	Exception string
+}
+
+type licenseAnd struct{ left, right licenseExpr }
+
+type licenseOr struct{ left, right licenseExpr }
+
+func (l licenseID) String() string {
+	if l.Exception != "" {
+		return l.ID + " WITH " + l.Exception
+	}
+	return l.ID
+}
+
+func (l licenseID) satisfied(ok func(licenseID) bool) (bool, []string) {
+	if ok(l) {
+		return true, nil
+	}
+	return false, []string{l.String()}
+}
+
+func (e licenseAnd) satisfied(ok func(licenseID) bool) (bool, []string) {
+	lok, lbad := e.left.satisfied(ok)
+	rok, rbad := e.right.satisfied(ok)
+	return lok && rok, append(lbad, rbad...)
+}
+
+func (e licenseOr) satisfied(ok func(licenseID) bool) (bool, []string) {
+	if lok, _ := e.left.satisfied(ok); lok {
+		return true, nil
+	}
+	if rok, _ := e.right.satisfied(ok); rok {
+		return true, nil
+	}
+	_, lbad := e.left.satisfied(ok)
+	_, rbad := e.right.satisfied(ok)
+	return false, append(lbad, rbad...)
+}
+
+// parseLicense parses an SPDX license expression such as
+// "MIT OR (Apache-2.0 AND GPL-2.0-only WITH Classpath-exception-2.0)".
+// AND binds tighter than OR.
+func parseLicense(s string) (licenseExpr, error) {
+	s = strings.ReplaceAll(s, "(", " ( ")
+	s = strings.ReplaceAll(s, ")", " ) ")
+	p := &licenseParser{tokens: strings.Fields(s)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty license expression")
+	}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok != "" {
+		return nil, fmt.Errorf("unexpected '%s' in license expression", tok)
+	}
+	return expr, nil
+}
+
+type licenseParser struct {
+	tokens []string
+}
+
+func (p *licenseParser) peek() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	return p.tokens[0]
+}
+
+func (p *licenseParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.tokens = p.tokens[1:]
+	}
+	return tok
+}
+
+func isOperator(tok string) bool {
+	switch strings.ToUpper(tok) {
+	case "AND", "OR", "WITH", "(", ")":
+		return true
+	}
+	return false
+}
+
+func (p *licenseParser) or() (licenseExpr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "OR") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = licenseOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *licenseParser) and() (licenseExpr, error) {
+	left, err := p.with()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "AND") {
+		p.next()
+		right, err := p.with()
+		if err != nil {
+			return nil, err
+		}
+		left = licenseAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *licenseParser) with() (licenseExpr, error) {
+	tok := p.next()
+	switch {
+	case tok == "(":
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing ')' in license expression")
+		}
+		return expr, nil
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of license expression")
+	case isOperator(tok):
+		return nil, fmt.Errorf("expected a license, got '%s'", tok)
+	}
+	id := licenseID{ID: tok}
+	if strings.EqualFold(p.peek(), "WITH") {
+		p.next()
+		exception := p.next()
+		if exception == "" || isOperator(exception) {
+			return nil, fmt.Errorf("expected an exception after WITH, got '%s'", exception)
+		}
+		id.Exception = exception
+	}
+	return id, nil
+}
+
+// licensePolicy decides which licenses may be downloaded. Entries are SPDX
+// ids or glob patterns; "GPL-3.0" also matches GPL-3.0-only and
+// GPL-3.0-or-later.
+type licensePolicy struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+func (p *licensePolicy) empty() bool {
+	return len(p.Allow) == 0 && len(p.Deny) == 0
+}
+
+// licenseMatches reports whether a policy entry covers the license.
+func licenseMatches(entry string, l licenseID) bool {
+	entry = strings.ToLower(entry)
+	id := strings.ToLower(l.ID)
+	if base, ok := strings.CutSuffix(id, "+"); ok {
+		id = base + "-or-later"
+	}
+	for _, candidate := range []string{id, strings.ToLower(l.String())} {
+		if ok, _ := path.Match(entry, candidate); ok {
+			return true
+		}
+	}
+	for _, suffix := range []string{"-only", "-or-later"} {
+		if id == entry+suffix {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *licensePolicy) permits(l licenseID) bool {
+	if slices.ContainsFunc(p.Deny, func(e string) bool { return licenseMatches(e, l) }) {
+		return false
+	}
+	return len(p.Allow) == 0 || slices.ContainsFunc(p.Allow, func(e string) bool { return licenseMatches(e, l) })
+}
+
+// check evaluates a formula's license, returning why it is refused.
+func (p *licensePolicy) check(license string) error {
+	if license == "" {
+		if len(p.Allow) > 0 {
+			return fmt.Errorf("no license")
+		}
+		return nil
+	}
+	// only an allow-list can't be satisfied by a license that isn't SPDX,
+	// a deny-list just can't name it
+	spdx := spdxExpression(license)
+	if spdx == "NOASSERTION" {
+		if len(p.Allow) > 0 {
+			return fmt.Errorf("license '%s' is not an SPDX expression", license)
+		}
+		logger.Warn("Ignoring license that is not an SPDX expression", "license", license)
+		return nil
+	}
+	expr, err := parseLicense(spdx)
+	if err != nil {
+		return fmt.Errorf("invalid license '%s': %w", license, err)
+	}
+	if ok, bad := expr.satisfied(p.permits); !ok {
+		return fmt.Errorf("%s", strings.Join(bad, ", "))
+	}
+	return nil
+}
+
+// config is the optional bottle-bomb config file.
+type config struct {
+	License licensePolicy `json:"license"`
+}
+
+// loadConfig reads $BOTTLE_BOMB_CONFIG or bottle-bomb/config.json in the user
+// config dir. A missing file is an empty config.
+func loadConfig() (*config, error) {
+	file := os.Getenv("BOTTLE_BOMB_CONFIG")
+	if file == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return &config{}, nil
+		}
+		file = filepath.Join(dir, "bottle-bomb", "config.json")
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &config{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	var c config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", file, err)
+	}
+	return &c, nil
+}
+
+// currentLicensePolicy merges the config file with --allow-license and
+// --deny-license.
+func currentLicensePolicy() (*licensePolicy, error) {
+	c, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	policy := c.License
+	policy.Allow = append(policy.Allow, allowLicenses...)
+	policy.Deny = append(policy.Deny, denyLicenses...)
+	return &policy, nil
+}
+
+// enforceLicenses refuses formula if it or any of its runtime dependencies
+// for tag is not permitted by the license policy.
+func enforceLicenses(ctx context.Context, formula *Formula, tag string) error {
+	policy, err := currentLicensePolicy()
+	if err != nil || policy.empty() {
+		return err
+	}
+	plan, err := planDependencies(ctx, formula, tag)
+	if err != nil {
+		return fmt.Errorf("failed to resolve dependencies for the license policy: %w", err)
+	}
+	var blocked []string
+	for _, n := range plan.Order {
+		if err := policy.check(n.Formula.License); err != nil {
+			blocked = append(blocked, fmt.Sprintf("%s (%s)", n.Formula.Name, err))
+		}
+	}
+	if len(blocked) > 0 {
+		return fmt.Errorf("%w: %s", errLicense, strings.Join(blocked, "; "))
+	}
+	return nil
+}
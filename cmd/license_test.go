@@ -0,0 +1,35 @@
+package cmd
+
+import "testing"
+
+func TestLicensePolicyCheck(t *testing.T) {
+	deny := &licensePolicy{Deny: []string{"GPL-3.0"}}
+	allow := &licensePolicy{Allow: []string{"MIT", "BSD-*"}}
+	tests := []struct {
+		name    string
+		policy  *licensePolicy
+		license string
+		ok      bool
+	}{
+		{"deny other", deny, "MIT", true},
+		{"deny match", deny, "GPL-3.0-or-later", false},
+		{"deny OR branch", deny, "GPL-3.0-only OR MIT", true},
+		{"deny AND branch", deny, "GPL-3.0-only AND MIT", false},
+		{"deny public domain", deny, "Public Domain", true},
+		{"deny cannot represent", deny, "Cannot Represent", true},
+		{"deny unparseable", deny, "MIT AND", true},
+		{"deny no license", deny, "", true},
+		{"allow match", allow, "BSD-3-Clause", true},
+		{"allow other", allow, "Apache-2.0", false},
+		{"allow cannot represent", allow, "Cannot Represent", false},
+		{"allow unparseable", allow, "MIT AND", false},
+		{"allow no license", allow, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.check(tt.license); (err == nil) != tt.ok {
+				t.Errorf("check(%q) = %v, want ok=%v", tt.license, err, tt.ok)
+			}
+		})
+	}
+}
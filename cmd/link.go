@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// linkDirs are the keg directories symlinked into the prefix, like `brew link`.
+var linkDirs = []string{"bin", "sbin", "lib", "include", "share", "etc"}
+
+// linkOptions control how a keg is linked into its prefix.
+type linkOptions struct {
+	Force     bool     // link keg-only formulae
+	Overwrite bool     // replace any conflicting file
+	Globs     []string // Formula.LinkOverwrite patterns that may be replaced
+}
+
+// linkConflictError lists the prefix paths that would be overwritten by a link.
+type linkConflictError struct {
+	keg       *Keg
+	conflicts []string
+}
+
+func (e *linkConflictError) Error() string {
+	return fmt.Sprintf("could not link %s, the following files already exist:\n  %s\n(use --overwrite to replace them)",
+		e.keg.Path(), strings.Join(e.conflicts, "\n  "))
+}
+
+// linkKeg creates the opt/<name> link and, unless the formula is keg-only,
+// symlinks the keg's files into the prefix. It returns the links it created.
+// Nothing is linked if any conflict is found, and if a link fails the ones
+// made so far are undone.
+func linkKeg(keg *Keg, formula *Formula, opts linkOptions) (links []string, err error) {
+	kegOnly := formula != nil && formula.KegOnly && !opts.Force
+
+	var plans []linkPlan
+	if !kegOnly {
+		var conflicts []string
+		if plans, conflicts, err = planLinks(keg, opts); err != nil {
+			return nil, err
+		}
+		if len(conflicts) > 0 {
+			return nil, &linkConflictError{keg: keg, conflicts: conflicts}
+		}
+	}
+
+	// what each link replaced, to put back if a later one fails
+	var replaced []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i, link := range slices.Backward(links) {
+			restoreSymlink(link, replaced[i], keg.Prefix)
+		}
+		links = nil
+	}()
+	link := func(dst, src string) error {
+		prev, _ := os.Readlink(dst)
+		l, err := replaceSymlink(dst, src)
+		if err != nil {
+			return err
+		}
+		links = append(links, l)
+		replaced = append(replaced, prev)
+		return nil
+	}
+
+	if err := link(keg.OptPath(), keg.Path()); err != nil {
+		return nil, err
+	}
+	if kegOnly {
+		logger.Warn("Formula is keg-only, not linking into prefix (use --force to link anyway)", "formula", keg.Name, "opt", keg.OptPath())
+		return links, nil
+	}
+	for _, p := range plans {
+		if err := link(p.dst, p.src); err != nil {
+			return links, err
+		}
+	}
+	if err := link(filepath.Join(keg.Prefix, "var", "homebrew", "linked", keg.Name), keg.Path()); err != nil {
+		return links, err
+	}
+	return links, nil
+}
+
+// restoreSymlink puts back the symlink target dst had before it was linked,
+// or removes dst (and the directories left empty up to prefix) if it had
+// none. Files replaced with --overwrite can't be brought back.
+func restoreSymlink(dst, target, prefix string) {
+	if target == "" {
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			logger.Error("Failed to remove link", "link", dst, "err", err)
+		}
+		pruneDirs(filepath.Dir(dst), prefix)
+		return
+	}
+	tmp := dst + ".bb-tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		logger.Error("Failed to restore link", "link", dst, "err", err)
+		return
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		logger.Error("Failed to restore link", "link", dst, "err", err)
+	}
+}
+
+// linkPlan is a single prefix symlink to create.
+type linkPlan struct{ src, dst string }
+
+// planLinks lists the links needed for keg and the prefix paths in the way.
+func planLinks(keg *Keg, opts linkOptions) ([]linkPlan, []string, error) {
+	var plans []linkPlan
+	var conflicts []string
+	for _, dir := range linkDirs {
+		root := filepath.Join(keg.Path(), dir)
+		if _, err := os.Lstat(root); err != nil {
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			rel, _ := filepath.Rel(keg.Path(), path)
+			dst := filepath.Join(keg.Prefix, rel)
+			if ok, err := linkable(dst, keg, rel, opts); err != nil {
+				return err
+			} else if !ok {
+				conflicts = append(conflicts, dst)
+			}
+			plans = append(plans, linkPlan{path, dst})
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to walk %s: %w", root, err)
+		}
+	}
+	return plans, conflicts, nil
+}
+
+// linkable reports whether dst can be (re)placed by a link into keg.
+func linkable(dst string, keg *Keg, rel string, opts linkOptions) (bool, error) {
+	fi, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", dst, err)
+	}
+	if opts.Overwrite {
+		return true, nil
+	}
+	for _, glob := range opts.Globs {
+		if ok, _ := filepath.Match(glob, rel); ok {
+			return true, nil
+		}
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return false, nil
+	}
+	// links into any version of the same formula are ours to replace
+	target, err := os.Readlink(dst)
+	if err != nil {
+		return false, fmt.Errorf("failed to read link %s: %w", dst, err)
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(dst), target)
+	}
+	return strings.HasPrefix(filepath.Clean(target)+string(filepath.Separator),
+		filepath.Join(keg.Prefix, "Cellar", keg.Name)+string(filepath.Separator)), nil
+}
+
+// replaceSymlink points dst at src using a relative link, replacing whatever
+// is at dst.
+func replaceSymlink(dst, src string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create dir: %w", err)
+	}
+	rel, err := filepath.Rel(filepath.Dir(dst), src)
+	if err != nil {
+		return "", fmt.Errorf("failed to make relative link: %w", err)
+	}
+	tmp := dst + ".bb-tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(rel, tmp); err != nil {
+		return "", fmt.Errorf("failed to create symlink: %w", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to link %s: %w", dst, err)
+	}
+	return dst, nil
+}
+
+// linkCmd represents the link command
+var linkCmd = &cobra.Command{
+	Use:           "link <formula>...",
+	Short:         "Symlink installed kegs into the prefix",
+	Args:          cobra.MinimumNArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prefix, err := prefixFlag(cmd)
+		if err != nil {
+			return err
+		}
+		force, _ := cmd.Flags().GetBool("force")
+		overwrite, _ := cmd.Flags().GetBool("overwrite")
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+
+		db, err := openInstallDB(prefix)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range args {
+			keg, err := findKeg(prefix, name)
+			if err != nil {
+				return err
+			}
+			formula, err := getFormula(ctx, name)
+			if err != nil {
+				return fmt.Errorf("failed to get formula '%s': %w", name, err)
+			}
+			links, err := linkKeg(keg, formula, linkOptions{
+				Force:     force,
+				Overwrite: overwrite,
+				Globs:     formula.LinkOverwrite,
+			})
+			for _, rec := range db.get(name) {
+				if rec.Version == keg.Version {
+					rec.Links = mergeLinks(rec.Links, db.rel(links))
+				}
+			}
+			if serr := db.save(); serr != nil && err == nil {
+				err = serr
+			}
+			if err != nil {
+				return err
+			}
+			logger.Info("Linked", "keg", keg.Path(), "links", len(links))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(linkCmd)
+	linkCmd.Flags().Bool("force", false, "Link keg-only formulae")
+	linkCmd.Flags().Bool("overwrite", false, "Replace conflicting files in the prefix")
+}
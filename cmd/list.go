@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// installedKeg is a keg in the prefix as shown by `list`.
+type installedKeg struct {
+	Name               string    `json:"name"`
+	Version            string    `json:"version"`
+	Tag                string    `json:"tag,omitempty"`
+	Rebuild            int       `json:"rebuild"`
+	VersionScheme      int       `json:"version_scheme"`
+	InstalledAt        time.Time `json:"installed_at"`
+	InstalledOnRequest bool      `json:"installed_on_request"`
+}
+
+// listInstalled describes every keg in prefix using its receipt and the
+// install database.
+func listInstalled(prefix string) ([]installedKeg, error) {
+	kegs, err := installedKegs(prefix)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openInstallDB(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var installed []installedKeg
+	for _, keg := range kegs {
+		ik := installedKeg{Name: keg.Name, Version: keg.Version}
+		for _, rec := range db.get(keg.Name) {
+			if rec.Version == keg.Version {
+				ik.Tag = rec.Tag
+				ik.Rebuild = rec.Rebuild
+				ik.InstalledAt = rec.InstalledAt
+				ik.InstalledOnRequest = rec.InstalledOnRequest
+			}
+		}
+		if r, err := readReceipt(keg); err != nil {
+			logger.Warn("Missing receipt", "keg", keg.Path(), "err", err)
+		} else {
+			ik.InstalledAt = r.InstalledAt()
+			ik.InstalledOnRequest = r.InstalledOnRequest
+			ik.VersionScheme = r.Source.Versions.VersionScheme
+		}
+		installed = append(installed, ik)
+	}
+	return installed, nil
+}
+
+// latestInstalled keeps only the newest keg of each formula.
+func latestInstalled(kegs []installedKeg) []installedKeg {
+	var latest []installedKeg
+	seen := make(map[string]int)
+	for _, k := range kegs {
+		if i, ok := seen[k.Name]; ok {
+			if comparePkgVersions(k.Version, latest[i].Version) > 0 {
+				latest[i] = k
+			}
+			continue
+		}
+		seen[k.Name] = len(latest)
+		latest = append(latest, k)
+	}
+	return latest
+}
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:           "list",
+	Aliases:       []string{"ls"},
+	Short:         "List kegs installed in the prefix",
+	Args:          cobra.NoArgs,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prefix, err := prefixFlag(cmd)
+		if err != nil {
+			return err
+		}
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		installed, err := listInstalled(prefix)
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			if installed == nil {
+				installed = []installedKeg{}
+			}
+			return printJSON(installed)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tVERSION\tTAG\tINSTALLED\tREASON")
+		for _, k := range installed {
+			tag := k.Tag
+			if tag == "" {
+				tag = "-"
+			}
+			date := "-"
+			if !k.InstalledAt.IsZero() {
+				date = k.InstalledAt.Local().Format(time.DateTime)
+			}
+			reason := "dependency"
+			if k.InstalledOnRequest {
+				reason = "on request"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k.Name, k.Version, tag, date, reason)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().Bool("json", false, "Print the installed kegs as JSON")
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+const ociImageIndex = "application/vnd.oci.image.index.v1+json"
+
+// getBottleIndex fetches the OCI image index Homebrew publishes alongside the
+// formula's bottles on ghcr.io.
+func getBottleIndex(ctx context.Context, formula *Formula) (*Bottle, error) {
+	return getBottleIndexAt(ctx, formula, formula.imageTag())
+}
+
+// getBottleIndexAt fetches the image index of the formula's bottles for an
+// image tag such as "1.7.1_1" or "1.7.1-1".
+func getBottleIndexAt(ctx context.Context, formula *Formula, ref string) (*Bottle, error) {
+	url := fmt.Sprintf(bottleAPI, formula.imageName(), ref)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Authorization", "Bearer QQ==")
+	req.Header.Set("Accept", ociImageIndex)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to http GET: %w", errNetwork, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s returned %s", errNoBottle, url, resp.Status)
+	default:
+		return nil, fmt.Errorf("%w: %s returned %s", errNetwork, url, resp.Status)
+	}
+
+	var bottle Bottle
+	if err := json.NewDecoder(resp.Body).Decode(&bottle); err != nil {
+		return nil, fmt.Errorf("failed to decode image index: %w", err)
+	}
+	return &bottle, nil
+}
+
+// getBottleTags lists the image tags of the formula on ghcr.io, one per
+// published version and rebuild.
+func getBottleTags(ctx context.Context, formula *Formula) ([]string, error) {
+	url := fmt.Sprintf(bottleTagsAPI, formula.imageName())
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Authorization", "Bearer QQ==")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to http GET: %w", errNetwork, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s returned %s", errNetwork, url, resp.Status)
+	}
+
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode tag list: %w", err)
+	}
+	return list.Tags, nil
+}
+
+// imageTagFor returns the newest image tag of version, which may be given
+// without its revision or rebuild ("1.7.1" matches "1.7.1_1-2").
+func imageTagFor(ctx context.Context, formula *Formula, version string) (string, error) {
+	tags, err := getBottleTags(ctx, formula)
+	if err != nil {
+		return "", err
+	}
+	var best string
+	var bestRevision, bestRebuild int
+	for _, t := range tags {
+		rest, ok := strings.CutPrefix(t, version)
+		if !ok {
+			continue
+		}
+		var revision, rebuild int
+		if rest != "" {
+			rev, reb, _ := strings.Cut(rest, "-")
+			if r, ok := strings.CutPrefix(rev, "_"); ok {
+				if revision, err = strconv.Atoi(r); err != nil {
+					continue
+				}
+			} else if rev != "" {
+				continue
+			}
+			if reb != "" {
+				if rebuild, err = strconv.Atoi(reb); err != nil {
+					continue
+				}
+			}
+		}
+		if best == "" || revision > bestRevision || revision == bestRevision && rebuild > bestRebuild {
+			best, bestRevision, bestRebuild = t, revision, rebuild
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("%w: '%s' has no bottles for version %s", errNoBottle, formula.Name, version)
+	}
+	return best, nil
+}
+
+// BottleFile returns the bottle blob of the image index for tag.
+func (b *Bottle) BottleFile(formula *Formula, tag string) (*BottleFile, error) {
+	m := b.Manifest(tag)
+	if m == nil || m.Annotations.ShBrewBottleDigest == "" {
+		return nil, fmt.Errorf("%w: '%s' %s has no bottle for tag '%s'", errNoBottle, formula.Name, b.Annotations.OrgOpencontainersImageRefName, tag)
+	}
+	digest := m.Annotations.ShBrewBottleDigest
+	return &BottleFile{
+		Tag:    tag,
+		Label:  tag,
+		URL:    fmt.Sprintf(bottleBlobAPI, formula.imageName(), digest),
+		Sha256: digest,
+	}, nil
+}
+
+// imageName is the ghcr.io repository name of the formula.
+func (f *Formula) imageName() string {
+	return strings.NewReplacer("@", "/", "+", "x").Replace(f.Name)
+}
+
+// pkgVersion is the stable version including the formula revision.
+func (f *Formula) pkgVersion() string {
+	if f.Revision > 0 {
+		return fmt.Sprintf("%s_%d", f.Versions.Stable, f.Revision)
+	}
+	return f.Versions.Stable
+}
+
+// imageTag is the ghcr.io tag of the formula's current bottles.
+func (f *Formula) imageTag() string {
+	if f.Bottle.Stable.Rebuild > 0 {
+		return fmt.Sprintf("%s-%d", f.pkgVersion(), f.Bottle.Stable.Rebuild)
+	}
+	return f.pkgVersion()
+}
+
+// Manifest returns the image manifest for the bottle tag, or nil.
+func (b *Bottle) Manifest(tag string) *BottleManifest {
+	if b == nil {
+		return nil
+	}
+	for i, m := range b.Manifests {
+		if strings.HasSuffix(m.Annotations.OrgOpencontainersImageRefName, "."+tag) {
+			return &b.Manifests[i]
+		}
+	}
+	return nil
+}
+
+// BottleSize is the size of the bottle tarball, or 0 when the index doesn't
+// annotate it. The manifest's own size is that of the JSON, not the bottle.
+func (m *BottleManifest) BottleSize() int64 {
+	if size, err := strconv.ParseInt(m.Annotations.ShBrewBottleSize, 10, 64); err == nil {
+		return size
+	}
+	return 0
+}
+
+// bottleSize formats a bottle size, which is 0 when unknown.
+func bottleSize(size int64) string {
+	if size <= 0 {
+		return "unknown"
+	}
+	return humanize.Bytes(uint64(size))
+}
+
+// cellarType describes the Cellar requirement of a bottle.
+func cellarType(cellar string) string {
+	switch cellar {
+	case ":any":
+		return "any (relocatable)"
+	case ":any_skip_relocation":
+		return "any (no relocation needed)"
+	case "":
+		return "unknown"
+	default:
+		return "fixed " + cellar
+	}
+}
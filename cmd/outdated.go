@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// outdatedKeg is an installed formula with a newer bottle available.
+type outdatedKeg struct {
+	Name             string `json:"name"`
+	InstalledVersion string `json:"installed_version"`
+	InstalledRebuild int    `json:"installed_rebuild"`
+	CurrentVersion   string `json:"current_version"`
+	CurrentRebuild   int    `json:"current_rebuild"`
+	Tag              string `json:"tag,omitempty"`
+	formula          *Formula
+}
+
+// isOutdated reports whether formula supersedes the installed keg. A bump of
+// the version scheme always wins, then the version and revision are compared,
+// then the bottle rebuild.
+func isOutdated(k installedKeg, formula *Formula) bool {
+	if formula.VersionScheme != k.VersionScheme {
+		return formula.VersionScheme > k.VersionScheme
+	}
+	if c := comparePkgVersions(k.Version, formula.pkgVersion()); c != 0 {
+		return c < 0
+	}
+	return k.Tag != "" && k.Rebuild < formula.Bottle.Stable.Rebuild
+}
+
+// findOutdated checks the newest keg of each installed formula (or only of
+// names, if given) against the formulae API.
+func findOutdated(ctx context.Context, prefix string, names []string) ([]outdatedKeg, error) {
+	installed, err := listInstalled(prefix)
+	if err != nil {
+		return nil, err
+	}
+	latest := latestInstalled(installed)
+
+	var outdated []outdatedKeg
+	for _, name := range names {
+		if !slices.ContainsFunc(latest, func(k installedKeg) bool { return k.Name == name }) {
+			return nil, fmt.Errorf("'%s' is not installed in %s", name, prefix)
+		}
+	}
+	for _, k := range latest {
+		if len(names) > 0 && !slices.Contains(names, k.Name) {
+			continue
+		}
+		formula, err := getFormula(ctx, k.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get formula '%s': %w", k.Name, err)
+		}
+		if isOutdated(k, formula) {
+			outdated = append(outdated, outdatedKeg{
+				Name:             k.Name,
+				InstalledVersion: k.Version,
+				InstalledRebuild: k.Rebuild,
+				CurrentVersion:   formula.pkgVersion(),
+				CurrentRebuild:   formula.Bottle.Stable.Rebuild,
+				Tag:              k.Tag,
+				formula:          formula,
+			})
+		}
+	}
+	return outdated, nil
+}
+
+// outdatedCmd represents the outdated command
+var outdatedCmd = &cobra.Command{
+	Use:           "outdated [formula...]",
+	Short:         "List installed kegs with newer bottles available",
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prefix, err := prefixFlag(cmd)
+		if err != nil {
+			return err
+		}
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+
+		outdated, err := findOutdated(ctx, prefix, args)
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			if outdated == nil {
+				outdated = []outdatedKeg{}
+			}
+			return printJSON(outdated)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tINSTALLED\tCURRENT")
+		for _, o := range outdated {
+			installed, current := o.InstalledVersion, o.CurrentVersion
+			if installed == current {
+				installed = fmt.Sprintf("%s (rebuild %d)", installed, o.InstalledRebuild)
+				current = fmt.Sprintf("%s (rebuild %d)", current, o.CurrentRebuild)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", o.Name, installed, current)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(outdatedCmd)
+	outdatedCmd.Flags().Bool("json", false, "Print the outdated kegs as JSON")
+}
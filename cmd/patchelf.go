@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Offsets into an ELF64 file header and its program and section headers.
+const (
+	elf64PhOff     = 0x20
+	elf64ShOff     = 0x28
+	elf64PhEntSize = 0x36
+	elf64ShEntSize = 0x3a
+	elf64DynSize   = 16
+)
+
+// relocateELF finishes relocating an ELF file whose in-place rewrite (out)
+// left long strings behind. Like patchelf, the dynamic string table is copied
+// to a new segment at the end of the file with the grown DT_NEEDED,
+// DT_SONAME, DT_RPATH and DT_RUNPATH strings appended, and the interpreter
+// moved alongside it. The segment reuses the PT_NOTE program header, which
+// the loader doesn't need. Long strings anywhere else still fail.
+func (r *relocator) relocateELF(data, out []byte, long []longString) ([]byte, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil || f.Class != elf.ELFCLASS64 {
+		return nil, long[0].err()
+	}
+	dynstr := f.Section(".dynstr")
+	if dynstr == nil || dynstr.Offset+dynstr.Size > uint64(len(data)) {
+		return nil, long[0].err()
+	}
+	bo := f.ByteOrder
+	replacer := strings.NewReplacer(r.replacements...)
+	moved := make(map[int]bool) // ends of the long strings taken care of
+	movable := func(start int, s string) bool {
+		end := start + len(s)
+		i := slices.IndexFunc(long, func(l longString) bool { return l.end == end && l.start >= start })
+		if i >= 0 {
+			moved[end] = true
+		}
+		return i >= 0
+	}
+
+	table := bytes.Clone(out[dynstr.Offset : dynstr.Offset+dynstr.Size])
+	var strtabAt, strszAt int // file offsets of the DT_STRTAB and DT_STRSZ values
+	var interp []byte
+	var interpProg, noteProg, lastLoad = -1, -1, -1
+	var align, end uint64 = 0x1000, 0
+	for i, p := range f.Progs {
+		switch p.Type {
+		case elf.PT_LOAD:
+			lastLoad = i
+			align = max(align, p.Align)
+			end = max(end, p.Vaddr+p.Memsz)
+		case elf.PT_NOTE:
+			noteProg = i
+		case elf.PT_INTERP:
+			if p.Off+p.Filesz > uint64(len(data)) {
+				return nil, long[0].err()
+			}
+			old := cstring(data[p.Off : p.Off+p.Filesz])
+			if movable(int(p.Off), old) {
+				interpProg = i
+				interp = append([]byte(replacer.Replace(old)), 0)
+			}
+		case elf.PT_DYNAMIC:
+			for at := int(p.Off); at+elf64DynSize <= int(p.Off+p.Filesz) && at+elf64DynSize <= len(data); at += elf64DynSize {
+				val := bo.Uint64(data[at+8:])
+				switch elf.DynTag(bo.Uint64(data[at:])) {
+				case elf.DT_STRTAB:
+					strtabAt = at + 8
+				case elf.DT_STRSZ:
+					strszAt = at + 8
+				case elf.DT_NEEDED, elf.DT_SONAME, elf.DT_RPATH, elf.DT_RUNPATH:
+					if val >= dynstr.Size {
+						continue
+					}
+					start := int(dynstr.Offset + val)
+					old := cstring(data[start:])
+					if movable(start, old) {
+						bo.PutUint64(out[at+8:], uint64(len(table)))
+						table = append(table, replacer.Replace(old)...)
+						table = append(table, 0)
+					}
+				}
+			}
+		}
+	}
+	for _, l := range long {
+		if !moved[l.end] {
+			return nil, l.err()
+		}
+	}
+	if strtabAt == 0 || strszAt == 0 {
+		return nil, long[0].err()
+	}
+	if noteProg < lastLoad {
+		return nil, fmt.Errorf("%w: no PT_NOTE after the last PT_LOAD to map the grown strings with", long[0].err())
+	}
+
+	// the new segment, with the same offset and address modulo the alignment
+	off := alignUp(uint64(len(out)), align)
+	addr := alignUp(end, align)
+	blob := slices.Concat(table, interp)
+	out = slices.Concat(out, make([]byte, off-uint64(len(out))), blob)
+
+	phoff := bo.Uint64(out[elf64PhOff:])
+	phentsize := uint64(bo.Uint16(out[elf64PhEntSize:]))
+	putProg := func(i int, typ elf.ProgType, off, addr, size, align uint64) {
+		ph := out[phoff+uint64(i)*phentsize:]
+		bo.PutUint32(ph[0:], uint32(typ))
+		bo.PutUint32(ph[4:], uint32(elf.PF_R))
+		bo.PutUint64(ph[8:], off)
+		bo.PutUint64(ph[16:], addr)
+		bo.PutUint64(ph[24:], addr)
+		bo.PutUint64(ph[32:], size)
+		bo.PutUint64(ph[40:], size)
+		bo.PutUint64(ph[48:], align)
+	}
+	putProg(noteProg, elf.PT_LOAD, off, addr, uint64(len(blob)), align)
+	bo.PutUint64(out[strtabAt:], addr)
+	bo.PutUint64(out[strszAt:], uint64(len(table)))
+	if interpProg >= 0 {
+		putProg(interpProg, elf.PT_INTERP, off+uint64(len(table)), addr+uint64(len(table)), uint64(len(interp)), 1)
+	}
+
+	// keep the section headers in step for tools that read those instead
+	shoff := bo.Uint64(out[elf64ShOff:])
+	shentsize := uint64(bo.Uint16(out[elf64ShEntSize:]))
+	for i, s := range f.Sections {
+		sh := shoff + uint64(i)*shentsize
+		if sh+shentsize > uint64(len(out)) {
+			break
+		}
+		switch {
+		case s == dynstr:
+			bo.PutUint64(out[sh+16:], addr)
+			bo.PutUint64(out[sh+24:], off)
+			bo.PutUint64(out[sh+32:], uint64(len(table)))
+		case s.Name == ".interp" && interpProg >= 0:
+			bo.PutUint64(out[sh+16:], addr+uint64(len(table)))
+			bo.PutUint64(out[sh+24:], off+uint64(len(table)))
+			bo.PutUint64(out[sh+32:], uint64(len(interp)))
+		}
+	}
+	return out, nil
+}
+
+// cstring returns b up to its first NUL.
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func alignUp(n, align uint64) uint64 {
+	return (n + align - 1) / align * align
+}
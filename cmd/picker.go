@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// pickerWidth is the width of the formula list, descriptions included.
+const pickerWidth = 56
+
+// pickerModel lets the user browse the formula index and pick a formula.
+type pickerModel struct {
+	state  state
+	lg     *lipgloss.Renderer
+	styles *Styles
+	form   *huh.Form
+	field  *huh.Select[string]
+	width  int
+
+	selected string
+	formulae map[string]*Formula
+}
+
+func newPickerModel(index []Formula) pickerModel {
+	m := pickerModel{
+		formulae: make(map[string]*Formula, len(index)),
+	}
+	m.lg = lipgloss.DefaultRenderer()
+	m.styles = NewStyles(m.lg)
+
+	options := make([]huh.Option[string], 0, len(index))
+	for i := range index {
+		f := &index[i]
+		m.formulae[f.Name] = f
+		options = append(options, huh.NewOption(pickerLabel(f), f.Name))
+	}
+
+	m.field = huh.NewSelect[string]().
+		Title("Formulae").
+		Options(options...).
+		Filtering(true).
+		Height(15).
+		Value(&m.selected)
+	m.form = huh.NewForm(huh.NewGroup(m.field)).
+		WithWidth(pickerWidth).
+		WithShowHelp(false).
+		WithShowErrors(false)
+
+	return m
+}
+
+// pickerLabel is the name, version and description of a formula, cut to fit
+// the list. Filtering matches the label, so the description can be searched.
+func pickerLabel(f *Formula) string {
+	label := fmt.Sprintf("%s (%s)", f.Name, f.Versions.Stable)
+	if f.Desc != "" {
+		label += " " + f.Desc
+	}
+	// leave room for the select's cursor
+	if r := []rune(label); len(r) > pickerWidth-4 {
+		label = string(r[:pickerWidth-5]) + "…"
+	}
+	return label
+}
+
+func (m pickerModel) Init() tea.Cmd {
+	return m.form.Init()
+}
+
+func (m pickerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = min(msg.Width, maxWidth) - m.styles.Base.GetHorizontalFrameSize()
+		return m, nil
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			m.state = stateQuitting
+			return m, tea.Quit
+		case "esc", "q":
+			// let the select clear its filter first
+			if !m.field.GetFiltering() {
+				m.state = stateQuitting
+				return m, tea.Quit
+			}
+		}
+	}
+
+	formModel, cmd := m.form.Update(msg)
+	if f, ok := formModel.(*huh.Form); ok {
+		m.form = f
+		if m.form.State == huh.StateCompleted {
+			m.state = stateDone
+			return m, tea.Quit
+		}
+	}
+	return m, cmd
+}
+
+func (m pickerModel) View() string {
+	s := m.styles
+	switch m.state {
+	case stateQuitting:
+		return lipgloss.NewStyle().Margin(1, 0, 2, 4).Render("🍺 Bottle dud? That's cool.")
+	case stateDone:
+		return ""
+	}
+
+	v := strings.TrimSuffix(m.form.View(), "\n")
+	form := m.lg.NewStyle().Margin(1, 0).Render(v)
+
+	body := form
+	if name, ok := m.field.Hovered(); ok {
+		if f, ok := m.formulae[name]; ok {
+			body = lipgloss.JoinHorizontal(lipgloss.Top, form, statusView(s, m.width, form, f, "", ""))
+		}
+	}
+
+	header := boundaryView(s, m.width, "🍺 Bottle Downloader")
+	footer := boundaryView(s, m.width, m.form.Help().ShortHelpView(m.form.KeyBinds()))
+
+	return s.Base.Render(header + "\n" + body + "\n\n" + footer)
+}
+
+// pickFormula opens the formula picker and returns the chosen formula name.
+func pickFormula(ctx context.Context) (string, error) {
+	index, err := cachedFormulaIndex(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get formula index: %w", err)
+	}
+
+	tm, err := tea.NewProgram(newPickerModel(index), tea.WithContext(ctx)).Run()
+	if err != nil {
+		return "", fmt.Errorf("failed to run program: %w", err)
+	}
+	m := tm.(pickerModel)
+	if m.state != stateDone || m.selected == "" {
+		return "", errCanceled
+	}
+	return m.selected, nil
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const receiptFile = "INSTALL_RECEIPT.json"
+
+// Receipt is the part of Homebrew's INSTALL_RECEIPT.json (a "tab") that
+// bottle-bomb reads back.
+type Receipt struct {
+	HomebrewVersion       string              `json:"homebrew_version"`
+	BuiltAsBottle         bool                `json:"built_as_bottle"`
+	PouredFromBottle      bool                `json:"poured_from_bottle"`
+	LoadedFromAPI         bool                `json:"loaded_from_api"`
+	InstalledAsDependency bool                `json:"installed_as_dependency"`
+	InstalledOnRequest    bool                `json:"installed_on_request"`
+	Time                  int64               `json:"time"`
+	Aliases               []string            `json:"aliases"`
+	RuntimeDependencies   []RuntimeDependency `json:"runtime_dependencies"`
+	Arch                  string              `json:"arch"`
+	Source                struct {
+		Spec       string `json:"spec"`
+		Tap        string `json:"tap"`
+		TapGitHead string `json:"tap_git_head"`
+		Versions   struct {
+			Stable        string `json:"stable"`
+			Head          string `json:"head"`
+			VersionScheme int    `json:"version_scheme"`
+		} `json:"versions"`
+	} `json:"source"`
+}
+
+// RuntimeDependency is a dependency recorded in a receipt.
+type RuntimeDependency struct {
+	FullName         string `json:"full_name"`
+	Version          string `json:"version,omitempty"`
+	Revision         int    `json:"revision,omitempty"`
+	PkgVersion       string `json:"pkg_version,omitempty"`
+	DeclaredDirectly bool   `json:"declared_directly"`
+}
+
+// InstalledAt is when the keg was poured.
+func (r *Receipt) InstalledAt() time.Time {
+	return time.Unix(r.Time, 0)
+}
+
+// writeReceipt writes a Homebrew compatible INSTALL_RECEIPT.json into keg so
+// `brew` treats it as a poured bottle. The receipt shipped inside the bottle
+// and the sh.brew.tab annotation of the bottle's manifest are used as a base.
+func writeReceipt(keg *Keg, formula *Formula, manifest *BottleManifest, onRequest bool) error {
+	return writeReceiptAs(keg, keg.Prefix, formula, manifest, onRequest)
+}
+
+// writeReceiptAs is writeReceipt for a keg staged outside of the prefix it
+// will be used from, e.g. in an image's rootfs.
+func writeReceiptAs(keg *Keg, prefix string, formula *Formula, manifest *BottleManifest, onRequest bool) error {
+	path := filepath.Join(keg.Path(), receiptFile)
+
+	tab := make(map[string]any)
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &tab); err != nil {
+			logger.Warn("Ignoring invalid receipt in bottle", "err", err)
+		}
+	}
+	if manifest != nil && manifest.Annotations.ShBrewTab != "" {
+		if err := json.Unmarshal([]byte(manifest.Annotations.ShBrewTab), &tab); err != nil {
+			logger.Warn("Ignoring invalid sh.brew.tab annotation", "err", err)
+		}
+	}
+
+	deps := []RuntimeDependency{}
+	if v, ok := tab["runtime_dependencies"].([]any); !ok || len(v) == 0 {
+		for _, dep := range formula.Dependencies {
+			deps = append(deps, RuntimeDependency{FullName: dep, DeclaredDirectly: true})
+		}
+		tab["runtime_dependencies"] = deps
+	}
+
+	source, _ := tab["source"].(map[string]any)
+	if source == nil {
+		source = make(map[string]any)
+	}
+	source["spec"] = "stable"
+	source["tap"] = formula.Tap
+	source["tap_git_head"] = formula.TapGitHead
+	source["path"] = filepath.Join(prefix, "Library", "Taps", "homebrew", "homebrew-core", formula.RubySourcePath)
+	versions := map[string]any{
+		"stable":         formula.Versions.Stable,
+		"head":           nil,
+		"version_scheme": formula.VersionScheme,
+	}
+	if formula.Versions.Head != "" {
+		versions["head"] = formula.Versions.Head
+	}
+	source["versions"] = versions
+	tab["source"] = source
+
+	aliases := append([]string{}, formula.Aliases...)
+
+	tab["built_as_bottle"] = true
+	tab["poured_from_bottle"] = true
+	tab["loaded_from_api"] = true
+	tab["installed_on_request"] = onRequest
+	tab["installed_as_dependency"] = !onRequest
+	tab["time"] = time.Now().Unix()
+	tab["aliases"] = aliases
+	for _, key := range []string{"used_options", "unused_options", "changed_files"} {
+		if _, ok := tab[key]; !ok {
+			tab[key] = []string{}
+		}
+	}
+
+	data, err := json.MarshalIndent(tab, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal receipt: %w", err)
+	}
+	os.Remove(path) // bottles ship it read-only
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write receipt: %w", err)
+	}
+	return nil
+}
+
+// readReceipt reads the INSTALL_RECEIPT.json of keg.
+func readReceipt(keg *Keg) (*Receipt, error) {
+	data, err := os.ReadFile(filepath.Join(keg.Path(), receiptFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read receipt: %w", err)
+	}
+	var r Receipt
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse receipt %s: %w", keg.Path(), err)
+	}
+	return &r, nil
+}
+
+// installedKegs lists every keg in <prefix>/Cellar.
+func installedKegs(prefix string) ([]*Keg, error) {
+	names, err := os.ReadDir(filepath.Join(prefix, "Cellar"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read Cellar: %w", err)
+	}
+	var kegs []*Keg
+	for _, name := range names {
+		if !name.IsDir() {
+			continue
+		}
+		versions, err := os.ReadDir(filepath.Join(prefix, "Cellar", name.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Cellar: %w", err)
+		}
+		for _, v := range versions {
+			if v.IsDir() && v.Name()[0] != '.' {
+				kegs = append(kegs, &Keg{Prefix: prefix, Name: name.Name(), Version: v.Name()})
+			}
+		}
+	}
+	return kegs, nil
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var loadedIndex struct {
+	once     sync.Once
+	formulae []Formula
+	err      error
+}
+
+// cachedFormulaIndex loads the formula index at most once per run.
+func cachedFormulaIndex(ctx context.Context) ([]Formula, error) {
+	loadedIndex.once.Do(func() {
+		loadedIndex.formulae, loadedIndex.err = getFormulaIndex(ctx)
+	})
+	return loadedIndex.formulae, loadedIndex.err
+}
+
+// resolveFormula maps an alias, old name or full name to the current formula
+// name using the formula index. If the index is unavailable the name is
+// returned as is.
+func resolveFormula(ctx context.Context, name string) (string, error) {
+	index, err := cachedFormulaIndex(ctx)
+	if err != nil {
+		logger.Debug("Formula index unavailable, not resolving aliases", "err", err)
+		return name, nil
+	}
+
+	lower := strings.ToLower(strings.TrimPrefix(name, "homebrew/core/"))
+	for _, f := range index {
+		if f.Name == lower {
+			return f.Name, nil
+		}
+	}
+	for _, f := range index {
+		switch {
+		case slices.Contains(f.Aliases, lower):
+			logger.Info("Resolved alias", "name", name, "formula", f.Name)
+			return f.Name, nil
+		case slices.Contains(f.Oldnames, lower):
+			logger.Info("Formula was renamed", "name", name, "formula", f.Name)
+			return f.Name, nil
+		}
+	}
+
+	err = fmt.Errorf("%w: no formula or alias named '%s'", errNotFound, name)
+	if suggestions := suggestFormulae(index, lower); len(suggestions) > 0 {
+		err = fmt.Errorf("%w; did you mean %s?", err, strings.Join(suggestions, ", "))
+	}
+	return "", err
+}
+
+// suggestFormulae returns up to five formula names close to name.
+func suggestFormulae(index []Formula, name string) []string {
+	type match struct {
+		name string
+		dist int
+	}
+	limit := max(2, len(name)/3)
+	var matches []match
+	for _, f := range index {
+		candidates := append([]string{f.Name}, f.Aliases...)
+		best := -1
+		for _, c := range candidates {
+			if c == "" {
+				continue
+			}
+			if d := levenshtein(name, c); d <= limit && (best < 0 || d < best) {
+				best = d
+			}
+		}
+		if best >= 0 {
+			matches = append(matches, match{f.Name, best})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int { return a.dist - b.dist })
+
+	var names []string
+	for _, m := range matches[:min(5, len(matches))] {
+		names = append(names, m.name)
+	}
+	return names
+}
+
+// levenshtein is the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
@@ -22,11 +22,17 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/log"
@@ -34,8 +40,10 @@ import (
 )
 
 const (
-	brewAPI   = "https://formulae.brew.sh/api/formula/%s.json"
-	bottleAPI = "https://ghcr.io/v2/homebrew/core/%s/manifests/%s" // 1st %s is the formula name; 2nd %s is the version
+	brewAPI       = "https://formulae.brew.sh/api/formula/%s.json"
+	bottleAPI     = "https://ghcr.io/v2/homebrew/core/%s/manifests/%s" // 1st %s is the formula name; 2nd %s is the version
+	bottleTagsAPI = "https://ghcr.io/v2/homebrew/core/%s/tags/list?n=10000"
+	bottleBlobAPI = "https://ghcr.io/v2/homebrew/core/%s/blobs/sha256:%s" // 2nd %s is the bottle sha256
 )
 
 var (
@@ -43,22 +51,62 @@ var (
 	p      *tea.Program
 )
 
-func getFormula(in string) (*Formula, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf(brewAPI, in), nil)
+// getFormula fetches a formula by name, alias or old name. The per-formula
+// API is asked first; the formula index is only loaded to resolve a name it
+// doesn't know, or to take the formula from when the API is signed.
+func getFormula(ctx context.Context, in string) (*Formula, error) {
+	// the per-formula API isn't signed, so take it from the verified index
+	if signedAPI() {
+		name, err := resolveFormula(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		index, err := cachedFormulaIndex(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i := range index {
+			if index[i].Name == name {
+				return &index[i], nil
+			}
+		}
+		return nil, fmt.Errorf("%w: '%s' is not in the formula index", errNotFound, name)
+	}
+
+	name := strings.ToLower(strings.TrimPrefix(in, "homebrew/core/"))
+	formula, err := fetchFormula(ctx, name)
+	if !errors.Is(err, errNotFound) {
+		return formula, err
+	}
+	resolved, rerr := resolveFormula(ctx, in)
+	if rerr != nil {
+		return nil, rerr
+	}
+	if resolved == name {
+		return nil, err
+	}
+	return fetchFormula(ctx, resolved)
+}
+
+// fetchFormula GETs a formula from the per-formula API.
+func fetchFormula(ctx context.Context, name string) (*Formula, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf(brewAPI, url.PathEscape(name)), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	// req.Header.Add("Authorization", "Bearer QQ==")
-	// req.Header.Set("Accept", "application/vnd.oci.image.index.v1+json")
 	req.Header.Set("Accept", "application/json")
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to http GET: %w", err)
+		return nil, fmt.Errorf("%w: failed to http GET: %w", errNetwork, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%s", resp.Status)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: '%s' %s", errNotFound, name, resp.Status)
+	default:
+		return nil, fmt.Errorf("%w: %s", errNetwork, resp.Status)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -76,48 +124,209 @@ func getFormula(in string) (*Formula, error) {
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:           "bottle-bomb <formula>",
+	Use:           "bottle-bomb [formula]",
 	Short:         "Download a homebrew bottle and install it",
-	Args:          cobra.ExactArgs(1),
+	Args:          cobra.MaximumNArgs(1),
 	SilenceErrors: true,
 	SilenceUsage:  true,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		asJSON, _ := cmd.Flags().GetBool("json")
+		tag, _ := cmd.Flags().GetString("tag")
+		force, _ := cmd.Flags().GetBool("force")
+		overwrite, _ := cmd.Flags().GetBool("overwrite")
+		allowDisabled, _ := cmd.Flags().GetBool("allow-disabled")
+		verify, _ := cmd.Flags().GetBool("verify-attestation")
+		bundlePath, _ := cmd.Flags().GetString("attestation")
+		rootPath, _ := cmd.Flags().GetString("trusted-root")
+		sbom, _ := cmd.Flags().GetString("sbom")
+		if _, ok := sbomFormats[sbom]; sbom != "" && !ok {
+			return fmt.Errorf("unknown SBOM format '%s' (use spdx or cyclonedx)", sbom)
+		}
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+
+		if len(args) == 0 {
+			if asJSON || tag != "" {
+				return fmt.Errorf("a formula is required with --json or --tag")
+			}
+			name, err := pickFormula(ctx)
+			if err != nil {
+				return err
+			}
+			args = append(args, name)
+		}
 
-		formula, err := getFormula(args[0])
+		formula, err := getFormula(ctx, args[0])
+		if err != nil {
+			err = fmt.Errorf("failed to get formula '%s': %w", args[0], err)
+		} else if !allowDisabled {
+			err = formula.checkDisabled()
+		}
 		if err != nil {
-			return fmt.Errorf("failed to get formula '%s': %w", args[0], err)
+			if asJSON {
+				res := downloadResult{Formula: args[0], Error: err.Error(), ExitCode: exitCode(err)}
+				if formula != nil {
+					res.setStatus(formula)
+				}
+				printJSON(res)
+			}
+			return err
+		}
+
+		var install bool
+		var prefix string
+		if cmd.Flags().Changed("prefix") {
+			if prefix, err = prefixFlag(cmd); err != nil {
+				return err
+			}
+			install = true
 		}
 
-		// if len(formula.Dependencies) > 0 {
-		// 	for _, dep := range formula.Dependencies {
-		// 		logger.Warn("Dependencies", "dep", dep)
-		// 	}
-		// }
+		interactive := !asJSON && tag == ""
+		if notice := formula.deprecationNotice(); notice != "" && !interactive && !asJSON {
+			logger.Warn(notice)
+		}
 
-		// Start Bubble Tea
-		// p = tea.NewProgram(initialModel(formula), tea.WithAltScreen())
-		p = tea.NewProgram(initialModel(formula))
+		var index *Bottle
+		if install || interactive {
+			if index, err = getBottleIndex(ctx, formula); err != nil {
+				logger.Warn("Failed to get bottle metadata", "err", err)
+			}
+		}
 
-		m, err := p.Run()
-		if err != nil {
-			return fmt.Errorf("failed to run program: %w", err)
+		var res *downloadResult
+		if interactive {
+			res, err = downloadInteractive(ctx, formula, index, install, prefix)
+		} else {
+			res, err = downloadNonInteractive(ctx, formula, tag, install, asJSON)
+		}
+		if err == nil && (verify || bundlePath != "") {
+			err = checkAttestation(ctx, res, bundlePath, rootPath)
 		}
-		if m, ok := m.(Model); ok {
-			if m.state == stateDownloading {
-				logger.Info("Creating", "file", fmt.Sprintf("%s.tar.gz", formula.Name))
+		if err == nil && sbom != "" {
+			var plan *depPlan
+			if plan, err = planDependencies(ctx, formula, res.Tag); err == nil {
+				res.SBOM, err = writeSBOM(sbom, plan, res)
+			}
+			if err == nil && !asJSON {
+				logger.Info("Wrote SBOM", "file", res.SBOM)
+			}
+		}
+		if err == nil && install {
+			var keg *Keg
+			keg, err = installBottle(formula, res, index, prefix, true, linkOptions{
+				Force:     force,
+				Overwrite: overwrite,
+				Globs:     formula.LinkOverwrite,
+			})
+			if keg != nil {
+				res.Keg = keg.Path()
 			}
 		}
 
-		return nil
+		if err == nil {
+			res.Caveats = formula.caveats(prefix)
+			if !asJSON {
+				printCaveats(res.Caveats)
+			}
+		}
+		if asJSON {
+			if err != nil {
+				if res == nil {
+					res = &downloadResult{Formula: formula.Name, Version: formula.Versions.Stable, Tag: tag}
+				}
+				res.Error = err.Error()
+				res.ExitCode = exitCode(err)
+			}
+			res.setStatus(formula)
+			printJSON(res)
+		}
+		return err
 	},
 }
 
+// downloadInteractive lets the user pick a bottle in the TUI and downloads it.
+func downloadInteractive(ctx context.Context, formula *Formula, index *Bottle, cached bool, prefix string) (*downloadResult, error) {
+	// if len(formula.Dependencies) > 0 {
+	// 	for _, dep := range formula.Dependencies {
+	// 		logger.Warn("Dependencies", "dep", dep)
+	// 	}
+	// }
+
+	// Start Bubble Tea
+	// p = tea.NewProgram(initialModel(formula), tea.WithAltScreen())
+	p = tea.NewProgram(initialModel(ctx, formula, index, cached, prefix), tea.WithContext(ctx))
+
+	tm, err := p.Run()
+	m, ok := tm.(Model)
+	if ok {
+		m.wait()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to run program: %w", err)
+	}
+	if !ok || m.state == stateQuitting {
+		return nil, errCanceled
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.result, nil
+}
+
+// downloadNonInteractive downloads the bottle for tag (or the host platform)
+// without starting the TUI.
+func downloadNonInteractive(ctx context.Context, formula *Formula, tag string, cached, quiet bool) (*downloadResult, error) {
+	bottle, err := formula.BottleFor(tag)
+	if err != nil {
+		return nil, err
+	}
+	if err := enforceLicenses(ctx, formula, bottle.Tag); err != nil {
+		return nil, err
+	}
+	dst, err := downloadPath(formula, bottle, cached)
+	if err != nil {
+		return nil, err
+	}
+	if !quiet {
+		logger.Info("Downloading", "formula", formula.Name, "version", formula.Versions.Stable, "tag", bottle.Tag)
+	}
+	res, err := fetchBottle(ctx, formula, bottle, dst, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !quiet {
+		logger.Info("Created", "file", res.Path, "sha256", res.Sha256, "size", res.Size)
+	}
+	return res, nil
+}
+
+// commandContext returns the command's context bounded by the --timeout flag.
+func commandContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	ctx := cmd.Context()
+	if timeout, _ := cmd.Flags().GetDuration("timeout"); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		stop()
 		logger.Error(err.Error())
-		// os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
@@ -131,4 +340,19 @@ func init() {
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	// rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "Abort after this long (e.g. 30s, 5m)")
+	rootCmd.PersistentFlags().BoolVar(&verifyAPI, "verify-api", hasEmbeddedKeys(), "Only trust formula metadata from the signed API (default on when built with its key)")
+	rootCmd.PersistentFlags().StringVar(&apiKeyPath, "api-key", "", "PEM public key to verify the signed API with, e.g. for a mirror (default $BOTTLE_BOMB_API_KEY)")
+	rootCmd.PersistentFlags().StringSliceVar(&denyLicenses, "deny-license", nil, "Refuse bottles whose dependency closure needs these licenses (SPDX ids or globs)")
+	rootCmd.PersistentFlags().StringSliceVar(&allowLicenses, "allow-license", nil, "Only allow bottles whose dependency closure can use these licenses")
+	rootCmd.PersistentFlags().String("prefix", "", "Homebrew prefix to pour and link bottles into (default $HOMEBREW_PREFIX for subcommands)")
+	rootCmd.Flags().Bool("json", false, "Download without the TUI and print the result as JSON")
+	rootCmd.Flags().StringP("tag", "t", "", "Bottle tag to download without the TUI (e.g. arm64_sonoma, x86_64_linux)")
+	rootCmd.Flags().Bool("allow-disabled", false, "Download formulae Homebrew has disabled")
+	rootCmd.Flags().Bool("force", false, "Link keg-only formulae into --prefix")
+	rootCmd.Flags().Bool("overwrite", false, "Replace conflicting files when linking into --prefix")
+	rootCmd.Flags().Bool("verify-attestation", false, "Verify the bottle's build provenance attestation before using it")
+	rootCmd.Flags().String("attestation", "", "Sigstore bundle to verify instead of fetching it from GitHub (implies --verify-attestation)")
+	rootCmd.Flags().String("sbom", "", "Write an SBOM of the bottle and its dependencies next to it (spdx or cyclonedx)")
+	rootCmd.Flags().String("trusted-root", "", "Sigstore trusted root JSON (default $BOTTLE_BOMB_TRUSTED_ROOT, trusted_root.json in the cache dir, or the built-in one)")
 }
@@ -0,0 +1,352 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sbomFormats maps the --sbom formats to the file suffix they are written with.
+var sbomFormats = map[string]string{
+	"spdx":      ".spdx.json",
+	"cyclonedx": ".cdx.json",
+}
+
+var (
+	spdxIDInvalid = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+	spdxLicenseID = regexp.MustCompile(`^[A-Za-z0-9.-]+\+?$`)
+)
+
+func spdxID(kind, name string) string {
+	return "SPDXRef-" + kind + "-" + spdxIDInvalid.ReplaceAllString(name, "-")
+}
+
+// spdxExpression turns a formulae API license into a valid SPDX expression.
+// The API writes :public_domain as "Public Domain" and :cannot_represent as
+// "Cannot Represent"; names that aren't SPDX ids become LicenseRef-<name>,
+// and a license that can't be represented or parsed is NOASSERTION.
+func spdxExpression(license string) string {
+	if strings.TrimSpace(license) == "" || strings.Contains(strings.ToLower(license), "cannot represent") {
+		return "NOASSERTION"
+	}
+	license = strings.ReplaceAll(license, "(", " ( ")
+	license = strings.ReplaceAll(license, ")", " ) ")
+
+	var tokens, words []string
+	flush := func() {
+		if len(words) == 0 {
+			return
+		}
+		id := strings.Join(words, " ")
+		if len(words) > 1 || !spdxLicenseID.MatchString(id) {
+			id = "LicenseRef-" + strings.Trim(spdxIDInvalid.ReplaceAllString(id, "-"), "-")
+		}
+		tokens = append(tokens, id)
+		words = nil
+	}
+	for _, tok := range strings.Fields(license) {
+		if !isOperator(tok) {
+			words = append(words, tok)
+			continue
+		}
+		flush()
+		tokens = append(tokens, strings.ToUpper(tok))
+	}
+	flush()
+
+	expr := strings.Join(tokens, " ")
+	expr = strings.ReplaceAll(strings.ReplaceAll(expr, "( ", "("), " )", ")")
+	if _, err := parseLicense(expr); err != nil {
+		return "NOASSERTION"
+	}
+	return expr
+}
+
+// spdxLicense returns the formula license as an SPDX expression.
+func (f *Formula) spdxLicense() string {
+	return spdxExpression(f.License)
+}
+
+// bottleDownloaded reports whether the bottle of n was fetched when the SBOM
+// was written: the plan's root is, dependencies only if they are in the
+// download cache. Otherwise its location and sha256 are what the formula
+// metadata says, not what was checked.
+func (p *depPlan) bottleDownloaded(n *depNode) bool {
+	if n == p.Root {
+		return true
+	}
+	if n.Bottle == nil {
+		return false
+	}
+	dst, err := downloadPath(n.Formula, n.Bottle, true)
+	return err == nil && cachedBottle(n.Formula, n.Bottle, dst) != nil
+}
+
+const notDownloadedComment = "Bottle not downloaded; its location and sha256 are from the formula metadata."
+
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+/* SPDX 2.3 */
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string         `json:"SPDXID"`
+	Name             string         `json:"name"`
+	VersionInfo      string         `json:"versionInfo,omitempty"`
+	DownloadLocation string         `json:"downloadLocation"`
+	Homepage         string         `json:"homepage,omitempty"`
+	Summary          string         `json:"summary,omitempty"`
+	LicenseConcluded string         `json:"licenseConcluded"`
+	LicenseDeclared  string         `json:"licenseDeclared"`
+	CopyrightText    string         `json:"copyrightText"`
+	FilesAnalyzed    bool           `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum `json:"checksums,omitempty"`
+	Comment          string         `json:"comment,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdxSBOM describes the bottles of the plan, each generated from its source
+// archive and depending on its runtime dependencies.
+func spdxSBOM(plan *depPlan) *spdxDocument {
+	root := plan.Root.Formula
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              fmt.Sprintf("%s-%s-%s", root.Name, root.pkgVersion(), plan.Tag),
+		DocumentNamespace: fmt.Sprintf("https://github.com/blacktop/bottle-bomb/spdx/%s-%s", root.Name, newUUID()),
+		CreationInfo: spdxCreationInfo{
+			Created:  time.Now().UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: bottle-bomb"},
+		},
+	}
+	doc.Relationships = append(doc.Relationships, spdxRelationship{doc.SPDXID, "DESCRIBES", spdxID("Package", root.Name)})
+
+	for _, n := range plan.Order {
+		f := n.Formula
+		pkg := spdxPackage{
+			SPDXID:           spdxID("Package", f.Name),
+			Name:             f.Name,
+			VersionInfo:      f.pkgVersion(),
+			DownloadLocation: "NOASSERTION",
+			Homepage:         f.Homepage,
+			Summary:          f.Desc,
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  f.spdxLicense(),
+			CopyrightText:    "NOASSERTION",
+		}
+		if n.Bottle != nil {
+			pkg.DownloadLocation = n.Bottle.URL
+			pkg.Checksums = []spdxChecksum{{"SHA256", n.Bottle.Sha256}}
+		}
+		if !plan.bottleDownloaded(n) {
+			pkg.Comment = notDownloadedComment
+		}
+		doc.Packages = append(doc.Packages, pkg)
+
+		if src := f.Urls.Stable; src.URL != "" {
+			source := spdxPackage{
+				SPDXID:           spdxID("Source", f.Name),
+				Name:             f.Name + "-source",
+				VersionInfo:      f.Versions.Stable,
+				DownloadLocation: src.URL,
+				Homepage:         f.Homepage,
+				LicenseConcluded: "NOASSERTION",
+				LicenseDeclared:  f.spdxLicense(),
+				CopyrightText:    "NOASSERTION",
+			}
+			if src.Using == "git" || src.Revision != "" {
+				source.DownloadLocation = "git+" + src.URL
+				if src.Revision != "" {
+					source.DownloadLocation += "@" + src.Revision
+				}
+			}
+			if src.Checksum != "" {
+				source.Checksums = []spdxChecksum{{"SHA256", src.Checksum}}
+			}
+			doc.Packages = append(doc.Packages, source)
+			doc.Relationships = append(doc.Relationships, spdxRelationship{pkg.SPDXID, "GENERATED_FROM", source.SPDXID})
+		}
+		for _, d := range n.Deps {
+			doc.Relationships = append(doc.Relationships, spdxRelationship{pkg.SPDXID, "DEPENDS_ON", spdxID("Package", d.Formula.Name)})
+		}
+	}
+	return doc
+}
+
+/* CycloneDX 1.5 */
+
+type cdxDocument struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string `json:"timestamp"`
+	Tools     struct {
+		Components []cdxComponent `json:"components"`
+	} `json:"tools"`
+	Component *cdxComponent `json:"component,omitempty"`
+}
+
+type cdxComponent struct {
+	Type               string           `json:"type"`
+	BOMRef             string           `json:"bom-ref,omitempty"`
+	Name               string           `json:"name"`
+	Version            string           `json:"version,omitempty"`
+	Description        string           `json:"description,omitempty"`
+	Licenses           []cdxLicense     `json:"licenses,omitempty"`
+	Hashes             []cdxHash        `json:"hashes,omitempty"`
+	ExternalReferences []cdxExternalRef `json:"externalReferences,omitempty"`
+	Properties         []cdxProperty    `json:"properties,omitempty"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxLicense struct {
+	Expression string `json:"expression"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxExternalRef struct {
+	Type   string    `json:"type"`
+	URL    string    `json:"url"`
+	Hashes []cdxHash `json:"hashes,omitempty"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// cyclonedxSBOM describes the bottles of the plan as components with their
+// dependency graph.
+func cyclonedxSBOM(plan *depPlan) *cdxDocument {
+	ref := func(f *Formula) string { return f.Name + "@" + f.pkgVersion() }
+	doc := &cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+	}
+	doc.Metadata.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	doc.Metadata.Tools.Components = []cdxComponent{{Type: "application", Name: "bottle-bomb"}}
+
+	for _, n := range plan.Order {
+		f := n.Formula
+		c := cdxComponent{
+			Type:        "application",
+			BOMRef:      ref(f),
+			Name:        f.Name,
+			Version:     f.pkgVersion(),
+			Description: f.Desc,
+		}
+		if license := f.spdxLicense(); license != "NOASSERTION" {
+			c.Licenses = []cdxLicense{{license}}
+		}
+		if f.Homepage != "" {
+			c.ExternalReferences = append(c.ExternalReferences, cdxExternalRef{Type: "website", URL: f.Homepage})
+		}
+		if n.Bottle != nil {
+			c.Hashes = []cdxHash{{"SHA-256", n.Bottle.Sha256}}
+			c.ExternalReferences = append(c.ExternalReferences, cdxExternalRef{Type: "distribution", URL: n.Bottle.URL})
+		}
+		if n.Bottle != nil {
+			c.Properties = []cdxProperty{{"bottle-bomb:downloaded", strconv.FormatBool(plan.bottleDownloaded(n))}}
+		}
+		if src := f.Urls.Stable; src.URL != "" {
+			source := cdxExternalRef{Type: "source-distribution", URL: src.URL}
+			if src.Checksum != "" {
+				source.Hashes = []cdxHash{{"SHA-256", src.Checksum}}
+			}
+			c.ExternalReferences = append(c.ExternalReferences, source)
+		}
+
+		dep := cdxDependency{Ref: c.BOMRef, DependsOn: []string{}}
+		for _, d := range n.Deps {
+			dep.DependsOn = append(dep.DependsOn, ref(d.Formula))
+		}
+		doc.Dependencies = append(doc.Dependencies, dep)
+
+		if n == plan.Root {
+			doc.Metadata.Component = &c
+		} else {
+			doc.Components = append(doc.Components, c)
+		}
+	}
+	if doc.Components == nil {
+		doc.Components = []cdxComponent{}
+	}
+	return doc
+}
+
+// writeSBOM writes an SBOM of the plan in format next to the downloaded
+// bottle and returns its path.
+func writeSBOM(format string, plan *depPlan, res *downloadResult) (string, error) {
+	var doc any
+	switch format {
+	case "spdx":
+		doc = spdxSBOM(plan)
+	case "cyclonedx":
+		doc = cyclonedxSBOM(plan)
+	default:
+		return "", fmt.Errorf("unknown SBOM format '%s' (use spdx or cyclonedx)", format)
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal SBOM: %w", err)
+	}
+	path := strings.TrimSuffix(res.Path, ".tar.gz") + sbomFormats[format]
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write SBOM: %w", err)
+	}
+	return path, nil
+}
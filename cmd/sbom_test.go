@@ -0,0 +1,26 @@
+package cmd
+
+import "testing"
+
+func TestSPDXExpression(t *testing.T) {
+	tests := []struct {
+		license, want string
+	}{
+		{"MIT", "MIT"},
+		{"GPL-2.0-or-later", "GPL-2.0-or-later"},
+		{"Apache-2.0 WITH LLVM-exception", "Apache-2.0 WITH LLVM-exception"},
+		{"MIT or Apache-2.0", "MIT OR Apache-2.0"},
+		{"(MIT OR Apache-2.0) AND BSD-3-Clause", "(MIT OR Apache-2.0) AND BSD-3-Clause"},
+		{"Public Domain", "LicenseRef-Public-Domain"},
+		{"MIT AND Public Domain", "MIT AND LicenseRef-Public-Domain"},
+		{"Cannot Represent", "NOASSERTION"},
+		{"", "NOASSERTION"},
+		{"Vim/Charityware", "LicenseRef-Vim-Charityware"},
+		{"MIT AND", "NOASSERTION"},
+	}
+	for _, tt := range tests {
+		if got := spdxExpression(tt.license); got != tt.want {
+			t.Errorf("spdxExpression(%q) = %q, want %q", tt.license, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// scanFinding is an entry of a bottle that is unsafe to extract.
+type scanFinding struct {
+	Path   string `json:"path"`
+	Issue  string `json:"issue"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// maxSymlinkHops bounds how many symlinks bottleLinks.resolve follows.
+const maxSymlinkHops = 40
+
+// bottleLinks maps the cleaned names of the symlinks seen so far in a bottle
+// to their targets, so chained links are judged by where they end up once
+// extracted rather than by their text.
+type bottleLinks map[string]string
+
+// via returns the earlier symlink that writing name would go through.
+func (l bottleLinks) via(name string) (string, bool) {
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, ok := l[dir]; ok {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// resolve follows the symlinks seen so far along name the way the filesystem
+// would and returns the path it ends at. It fails on an absolute target or a
+// loop.
+func (l bottleLinks) resolve(name string) (string, bool) {
+	todo := strings.Split(name, "/")
+	cur := ""
+	for hops := 0; len(todo) > 0; {
+		part := todo[0]
+		todo = todo[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			cur = path.Join(cur, "..")
+			continue
+		}
+		next := path.Join(cur, part)
+		target, ok := l[next]
+		if !ok {
+			cur = next
+			continue
+		}
+		if hops++; hops > maxSymlinkHops || path.IsAbs(target) {
+			return "", false
+		}
+		todo = append(strings.Split(target, "/"), todo...)
+	}
+	return cur, true
+}
+
+// kegDir returns the <name>/<version> directory a bottle entry belongs to.
+func kegDir(name string) string {
+	parts := strings.SplitN(name, "/", 3)
+	return strings.Join(parts[:min(2, len(parts))], "/")
+}
+
+// scanBottle checks the tar headers of a gzipped bottle for entries that
+// could escape the keg or grant privileges when extracted as root.
+func scanBottle(r io.Reader) ([]scanFinding, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip: %w", err)
+	}
+	defer gz.Close()
+
+	var findings []scanFinding
+	add := func(hdr *tar.Header, issue, detail string) {
+		findings = append(findings, scanFinding{Path: hdr.Name, Issue: issue, Detail: detail})
+	}
+	seen := make(map[string]bool)
+	links := make(bottleLinks)
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return findings, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar: %w", err)
+		}
+
+		name := path.Clean(hdr.Name)
+		switch {
+		case path.IsAbs(hdr.Name):
+			add(hdr, "absolute path", "")
+		case slices.Contains(strings.Split(hdr.Name, "/"), ".."):
+			add(hdr, "parent directory", "path contains '..'")
+		}
+		if via, ok := links.via(name); ok {
+			add(hdr, "path through symlink", "via "+via)
+		}
+		seen[name] = true
+
+		keg := kegDir(name)
+
+		switch hdr.Typeflag {
+		case tar.TypeChar, tar.TypeBlock:
+			add(hdr, "device file", fmt.Sprintf("%d:%d", hdr.Devmajor, hdr.Devminor))
+		case tar.TypeFifo:
+			add(hdr, "named pipe", "")
+		case tar.TypeSymlink:
+			target, ok := links.resolve(path.Dir(name) + "/" + hdr.Linkname)
+			switch {
+			case path.IsAbs(hdr.Linkname):
+				add(hdr, "absolute symlink", "-> "+hdr.Linkname)
+			case !ok || !within(target, keg):
+				add(hdr, "symlink escapes keg", "-> "+hdr.Linkname)
+			}
+			links[name] = hdr.Linkname
+		case tar.TypeLink:
+			target := path.Clean(hdr.Linkname)
+			_, through := links.via(target)
+			switch {
+			case path.IsAbs(hdr.Linkname) || !within(target, keg):
+				add(hdr, "hardlink outside keg", "=> "+hdr.Linkname)
+			case through:
+				add(hdr, "hardlink through symlink", "=> "+hdr.Linkname)
+			case !seen[target]:
+				add(hdr, "hardlink to missing entry", "=> "+hdr.Linkname)
+			}
+		}
+
+		mode := hdr.Mode
+		if mode&0o4000 != 0 {
+			add(hdr, "setuid", fmt.Sprintf("mode %04o", mode&0o7777))
+		}
+		if mode&0o2000 != 0 {
+			add(hdr, "setgid", fmt.Sprintf("mode %04o", mode&0o7777))
+		}
+		if mode&0o002 != 0 && hdr.Typeflag != tar.TypeSymlink {
+			add(hdr, "world-writable", fmt.Sprintf("mode %04o", mode&0o7777))
+		}
+	}
+}
+
+// scanBottleFile scans the bottle at path, failing with errUnsafe if any
+// entry is flagged.
+func scanBottleFile(path string) ([]scanFinding, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bottle: %w", err)
+	}
+	defer f.Close()
+	findings, err := scanBottle(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(findings) > 0 {
+		return findings, fmt.Errorf("%w: %s has %d unsafe entries (see `bottle-bomb scan`)", errUnsafe, path, len(findings))
+	}
+	return nil, nil
+}
+
+// scanCmd represents the scan command
+var scanCmd = &cobra.Command{
+	Use:           "scan <formula|bottle.tar.gz>",
+	Short:         "Check a bottle for entries that are unsafe to extract",
+	Args:          cobra.ExactArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tag, _ := cmd.Flags().GetString("tag")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+
+		path := args[0]
+		if fi, err := os.Stat(path); err != nil || fi.IsDir() {
+			formula, err := getFormula(ctx, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to get formula '%s': %w", args[0], err)
+			}
+			res, err := downloadNonInteractive(ctx, formula, tag, true, asJSON)
+			if err != nil {
+				return err
+			}
+			path = res.Path
+		}
+
+		findings, err := scanBottleFile(path)
+		if asJSON {
+			if findings == nil {
+				findings = []scanFinding{}
+			}
+			printJSON(map[string]any{"path": path, "findings": findings})
+			return err
+		}
+		if len(findings) > 0 {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PATH\tISSUE\tDETAIL")
+			for _, f := range findings {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", f.Path, f.Issue, f.Detail)
+			}
+			w.Flush()
+		} else if err == nil {
+			logger.Info("No unsafe entries", "bottle", path)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scanCmd)
+	scanCmd.Flags().StringP("tag", "t", "", "Bottle tag to scan (defaults to the host)")
+	scanCmd.Flags().Bool("json", false, "Print the findings as JSON")
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+)
+
+// testBottle gzips a tarball of entries; a Linkname makes a symlink, or a
+// hardlink when it starts with "=". Files are filled with Size bytes.
+func testBottle(t *testing.T, entries ...tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range entries {
+		switch {
+		case hdr.Typeflag != 0:
+		case len(hdr.Linkname) > 0 && hdr.Linkname[0] == '=':
+			hdr.Typeflag, hdr.Linkname = tar.TypeLink, hdr.Linkname[1:]
+		case hdr.Linkname != "":
+			hdr.Typeflag = tar.TypeSymlink
+		default:
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0o644
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(bytes.Repeat([]byte{'x'}, int(hdr.Size))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestScanBottleLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tar.Header
+		issues  []string
+	}{
+		{"relative symlink", []tar.Header{
+			{Name: "jq/1.7.1/lib/libjq.1.dylib"},
+			{Name: "jq/1.7.1/lib/libjq.dylib", Linkname: "libjq.1.dylib"},
+		}, nil},
+		{"symlink escapes", []tar.Header{
+			{Name: "jq/1.7.1/evil", Linkname: "../../.."},
+		}, []string{"symlink escapes keg"}},
+		{"chained symlinks escape", []tar.Header{
+			{Name: "jq/1.7.1/a", Linkname: "."},
+			{Name: "jq/1.7.1/b", Linkname: "a/a/a/../.."},
+		}, []string{"symlink escapes keg"}},
+		{"symlink into a symlinked dir", []tar.Header{
+			{Name: "jq/1.7.1/share/man", Linkname: "../lib"},
+			{Name: "jq/1.7.1/share/doc", Linkname: "man/../.."},
+		}, []string{"symlink escapes keg"}},
+		{"write through symlink", []tar.Header{
+			{Name: "jq/1.7.1/lib", Linkname: "share"},
+			{Name: "jq/1.7.1/lib/libjq.dylib"},
+		}, []string{"path through symlink"}},
+		{"hardlink through symlink", []tar.Header{
+			{Name: "jq/1.7.1/etc", Linkname: "share"},
+			{Name: "jq/1.7.1/bin/jq", Linkname: "=jq/1.7.1/etc/passwd"},
+		}, []string{"hardlink through symlink"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, err := scanBottle(bytes.NewReader(testBottle(t, tt.entries...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var issues []string
+			for _, f := range findings {
+				issues = append(issues, f.Issue)
+			}
+			if len(issues) != len(tt.issues) {
+				t.Fatalf("issues = %q, want %q", issues, tt.issues)
+			}
+			for i := range issues {
+				if issues[i] != tt.issues[i] {
+					t.Errorf("issues = %q, want %q", issues, tt.issues)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractBottleThroughSymlink(t *testing.T) {
+	data := testBottle(t,
+		tar.Header{Name: "jq/1.7.1/lib", Linkname: "../../.."},
+		tar.Header{Name: "jq/1.7.1/lib/pwned"},
+	)
+	err := extractBottle(bytes.NewReader(data), t.TempDir(), nil)
+	if !errors.Is(err, errUnsafe) {
+		t.Fatalf("extractBottle() = %v, want %v", err, errUnsafe)
+	}
+}
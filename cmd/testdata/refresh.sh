@@ -0,0 +1,23 @@
+#!/bin/sh
+# Refreshes the samples from the formulae API. The fields that change with
+# every release (bottle checksums, source checksums, tap heads) are dropped;
+# see README.md.
+set -eu
+cd "$(dirname "$0")"
+
+trim='del(.bottle, .tap_git_head, .ruby_source_checksum, .urls[]?.checksum)'
+
+fetch() {
+	curl -fsSL "https://formulae.brew.sh/api/formula/$1.json" | jq "$trim" > "$2.tmp"
+	mv "$2.tmp" "$2"
+}
+
+fetch curl curl.json
+fetch postgresql@17 postgresql@17.json
+fetch unbound per-os-service.json
+fetch swiftformat requirements.json
+
+# the index, with a copy of curl whose revision has the wrong type
+jq -s '.[0:2] + [.[0] | .name = "broken" | .full_name = "broken" | .revision = "1"] + .[2:]' \
+	curl.json postgresql@17.json per-os-service.json requirements.json > formula.json.tmp
+mv formula.json.tmp formula.json
@@ -1,17 +1,20 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/progress"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/huh" // Add the 'huh' package
 	"github.com/charmbracelet/lipgloss"
+	"github.com/dustin/go-humanize"
 )
 
 const maxWidth = 100
@@ -71,18 +74,9 @@ const (
 type progressWriter struct {
 	total      int
 	downloaded int
-	file       *os.File
-	reader     io.Reader
 	onProgress func(float64)
 }
 
-func (pw *progressWriter) Start() {
-	// TeeReader calls pw.Write() each time a new response is received
-	if _, err := io.Copy(pw.file, io.TeeReader(pw.reader, pw)); err != nil {
-		p.Send(progressErrMsg{err})
-	}
-}
-
 func (pw *progressWriter) Write(p []byte) (int, error) {
 	pw.downloaded += len(p)
 	if pw.total > 0 && pw.onProgress != nil {
@@ -91,9 +85,21 @@ func (pw *progressWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+/* download messages */
+
+type downloadStartedMsg struct{ total int64 }
+
 type progressMsg float64
 
-type progressErrMsg struct{ err error }
+type downloadDoneMsg struct{ result *downloadResult }
+
+type downloadErrMsg struct{ err error }
+
+type depPlanMsg struct {
+	tag  string
+	plan *depPlan
+	err  error
+}
 
 func finalPause() tea.Cmd {
 	return tea.Tick(time.Millisecond*750, func(_ time.Time) tea.Msg {
@@ -104,66 +110,66 @@ func finalPause() tea.Cmd {
 /* model */
 
 type Model struct {
+	ctx    context.Context
+	cancel context.CancelFunc
 	state  state
 	lg     *lipgloss.Renderer
 	styles *Styles
 	form   *huh.Form // Replace 'list' with 'form'
+	field  *huh.Select[string]
 	width  int
 
-	selectedURL string // To store the selected URL
+	selectedTag string // To store the selected bottle tag
 	formula     *Formula
+	index       *Bottle // OCI image index; nil if it couldn't be fetched
+	result      *downloadResult
+	total       int64
 	err         error
+	cached      bool                   // save into the download cache instead of the working directory
+	prefix      string                 // prefix to look for installed dependencies in, if any
+	plans       map[string]*depPlanMsg // dependency plans by tag; nil while resolving
+	expanded    map[string]bool        // dependency tree nodes shown open, by path
+	node        int                    // dependency tree row the cursor is on
+	started     bool
+	finished    chan struct{} // closed once the download command returns
 
-	pw       *progressWriter
 	progress progress.Model
-	created  bool
 }
 
-func initialModel(formula *Formula) Model {
+func initialModel(ctx context.Context, formula *Formula, index *Bottle, cached bool, prefix string) Model {
 	m := Model{
-		formula: formula,
+		formula:  formula,
+		index:    index,
+		cached:   cached,
+		prefix:   prefix,
+		plans:    make(map[string]*depPlanMsg),
+		expanded: make(map[string]bool),
+		finished: make(chan struct{}),
 	}
+	m.ctx, m.cancel = context.WithCancel(ctx)
 	m.lg = lipgloss.DefaultRenderer()
 	m.styles = NewStyles(m.lg)
 
 	var options []huh.Option[string]
 
 	// Build the options from the available files
-	if formula.Bottle.Stable.Files.Arm64Sonoma.URL != "" {
-		options = append(options, huh.NewOption("macOS Sonoma (arm64)", formula.Bottle.Stable.Files.Arm64Sonoma.URL))
-	}
-	if formula.Bottle.Stable.Files.Arm64Ventura.URL != "" {
-		options = append(options, huh.NewOption("macOS Ventura (arm64)", formula.Bottle.Stable.Files.Arm64Ventura.URL))
-	}
-	if formula.Bottle.Stable.Files.Arm64Monterey.URL != "" {
-		options = append(options, huh.NewOption("macOS Monterey (arm64)", formula.Bottle.Stable.Files.Arm64Monterey.URL))
-	}
-	if formula.Bottle.Stable.Files.Sonoma.URL != "" {
-		options = append(options, huh.NewOption("macOS Sonoma (x86_64)", formula.Bottle.Stable.Files.Sonoma.URL))
-	}
-	if formula.Bottle.Stable.Files.Ventura.URL != "" {
-		options = append(options, huh.NewOption("macOS Ventura (x86_64)", formula.Bottle.Stable.Files.Ventura.URL))
-	}
-	if formula.Bottle.Stable.Files.Monterey.URL != "" {
-		options = append(options, huh.NewOption("macOS Monterey (x86_64)", formula.Bottle.Stable.Files.Monterey.URL))
-	}
-	if formula.Bottle.Stable.Files.Arm64Linux.URL != "" {
-		options = append(options, huh.NewOption("Linux (arm64)", formula.Bottle.Stable.Files.Arm64Linux.URL))
-	}
-	if formula.Bottle.Stable.Files.X8664Linux.URL != "" {
-		options = append(options, huh.NewOption("Linux (x86_64)", formula.Bottle.Stable.Files.X8664Linux.URL))
+	for _, b := range formula.Bottles() {
+		label := b.Label
+		if mf := index.Manifest(b.Tag); mf != nil {
+			label += " · " + bottleSize(mf.BottleSize())
+		}
+		options = append(options, huh.NewOption(label, b.Tag))
 	}
 
 	// Create the form
+	m.field = huh.NewSelect[string]().
+		Title(fmt.Sprintf("'%s' Bottles", formula.Name)).
+		Options(options...).
+		Value(&m.selectedTag)
 	m.form = huh.NewForm(
-		huh.NewGroup(
-			huh.NewSelect[string]().
-				Title(fmt.Sprintf("'%s' Bottles", formula.Name)).
-				Options(options...).
-				Value(&m.selectedURL),
-		),
+		huh.NewGroup(m.field),
 	).
-		WithWidth(30).
+		WithWidth(36).
 		WithShowHelp(false).
 		WithShowErrors(false)
 
@@ -197,22 +203,38 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "esc", "ctrl+c", "q":
-			m.state = stateQuitting
+			if m.state != stateDone {
+				m.state = stateQuitting
+				m.cancel()
+			}
 			return m, tea.Quit
+		case "shift+up", "shift+down", "left", "right":
+			if m.state == statusNormal && !m.field.GetFiltering() {
+				m.treeKey(msg.String())
+				return m, nil
+			}
 		}
-	case progressErrMsg:
-		m.err = msg.err
-		return m, tea.Quit
+
+	case depPlanMsg:
+		m.plans[msg.tag] = &msg
+		return m, nil
+
+	case downloadStartedMsg:
+		m.total = msg.total
+		return m, nil
 
 	case progressMsg:
-		var cmds []tea.Cmd
+		return m, m.progress.SetPercent(float64(msg))
 
-		if msg >= 1.0 {
-			cmds = append(cmds, tea.Sequence(finalPause(), tea.Quit))
-		}
+	case downloadDoneMsg:
+		m.state = stateDone
+		m.result = msg.result
+		return m, tea.Batch(m.progress.SetPercent(1.0), tea.Sequence(finalPause(), tea.Quit))
 
-		cmds = append(cmds, m.progress.SetPercent(float64(msg)))
-		return m, tea.Batch(cmds...)
+	case downloadErrMsg:
+		m.state = stateDone
+		m.err = msg.err
+		return m, tea.Quit
 
 	// FrameMsg is sent when the progress bar wants to animate itself
 	case progress.FrameMsg:
@@ -226,13 +248,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	formModel, cmd := m.form.Update(msg)
 	if f, ok := formModel.(*huh.Form); ok {
 		m.form = f
-		if m.form.State == huh.StateCompleted && m.state != stateDownloading {
+		if m.form.State == huh.StateCompleted && m.state == statusNormal {
 			m.state = stateDownloading
+			m.started = true
 			return m, m.downloadBottle()
-		} else if m.form.State == huh.StateCompleted && m.state == stateDone {
-			return m, tea.Quit
 		}
-		cmds = append(cmds, cmd)
+		cmds = append(cmds, cmd, m.planDependencies())
 	}
 
 	// Keep the progress bar ticking
@@ -245,7 +266,11 @@ func (m Model) View() string {
 	case stateDownloading:
 		header := m.appBoundaryView("🍺 Bottle Downloader")
 		progressView := m.lg.NewStyle().Margin(1, 1, 0, 4).Render(m.progress.View())
-		footer := m.appBoundaryView("Downloading... Press 'q' to quit")
+		status := "Downloading..."
+		if m.total > 0 {
+			status = fmt.Sprintf("Downloading %s...", humanize.Bytes(uint64(m.total)))
+		}
+		footer := m.appBoundaryView(status + " Press 'q' to quit")
 		// return s.Base.Render(form + "\n\n" + progressView + "\n\n" + footer)
 		return s.Base.Render(header + "\n" + progressView + "\n\n" + footer)
 
@@ -265,36 +290,21 @@ func (m Model) View() string {
 		}
 		header := m.appBoundaryView("🍾 Download Complete! 💥")
 		progressView := m.lg.NewStyle().Margin(1, 1, 0, 4).Render(m.progress.View())
-		footer := m.appBoundaryView("Downloading... Press 'q' to quit")
-		// return s.Base.Render(form + "\n\n" + progressView + "\n\n" + footer)
-		return s.Base.Render(header + "\n" + progressView + "\n\n" + footer)
+		summary := s.Status.Margin(1, 1, 0, 4).Padding(0, 1).Render(
+			s.StatusHeader.Render(m.result.Formula+" "+m.result.Version) + "\n" +
+				"Tag:     " + m.result.Tag + "\n" +
+				"Path:    " + m.result.Path + "\n" +
+				"Size:    " + humanize.Bytes(uint64(m.result.Size)) + "\n" +
+				"SHA256:  " + m.result.Sha256 + "\n" +
+				"Elapsed: " + time.Duration(m.result.Duration*float64(time.Second)).Round(time.Millisecond).String(),
+		)
+		return s.Base.Render(header + "\n" + progressView + "\n" + summary + "\n")
 
 	default:
 		v := strings.TrimSuffix(m.form.View(), "\n")
 		form := m.lg.NewStyle().Margin(1, 0).Render(v)
 
-		var status string
-		{
-			var deps string
-			if len(m.formula.Dependencies) > 0 {
-				deps = "\n\n" + s.StatusHeader.Render("Dependencies") + "\n"
-				for _, dep := range m.formula.Dependencies {
-					deps += "  • " + dep + "\n"
-				}
-			}
-			const statusWidth = 60
-			statusMarginLeft := m.width - statusWidth - lipgloss.Width(form) - s.Status.GetMarginRight()
-			status = s.Status.
-				Height(lipgloss.Height(form)).
-				Width(statusWidth).
-				MarginLeft(statusMarginLeft).
-				Render(s.StatusHeader.Render(m.formula.Name)+"\n"+
-					"Version: "+m.formula.Versions.Stable+"\n"+
-					"Homepage: "+m.formula.Homepage+"\n"+
-					"Description: "+m.formula.Desc,
-					deps,
-				)
-		}
+		status := statusView(s, m.width, form, m.formula, m.depsView(), m.bottleView())
 		errors := m.errorView()
 		header := m.appBoundaryView("🍺 Bottle Downloader")
 		if len(errors) > 0 {
@@ -302,7 +312,7 @@ func (m Model) View() string {
 		}
 		body := lipgloss.JoinHorizontal(lipgloss.Top, form, status)
 
-		footer := m.appBoundaryView(m.form.Help().ShortHelpView(m.form.KeyBinds()))
+		footer := m.appBoundaryView(m.form.Help().ShortHelpView(append(m.form.KeyBinds(), depsKey, foldKey)))
 		if len(errors) > 0 {
 			footer = m.appErrorBoundaryView("")
 		}
@@ -320,59 +330,267 @@ func (m Model) errorView() string {
 }
 
 func (m Model) appBoundaryView(text string) string {
+	return boundaryView(m.styles, m.width, text)
+}
+
+func (m Model) appErrorBoundaryView(text string) string {
+	return errorBoundaryView(m.styles, m.width, text)
+}
+
+func boundaryView(s *Styles, width int, text string) string {
 	return lipgloss.PlaceHorizontal(
-		m.width,
+		width,
 		lipgloss.Left,
-		m.styles.HeaderText.Render(text),
+		s.HeaderText.Render(text),
 		lipgloss.WithWhitespaceChars("/"),
 		lipgloss.WithWhitespaceForeground(indigo),
 	)
 }
 
-func (m Model) appErrorBoundaryView(text string) string {
+func errorBoundaryView(s *Styles, width int, text string) string {
 	return lipgloss.PlaceHorizontal(
-		m.width,
+		width,
 		lipgloss.Left,
-		m.styles.ErrorHeaderText.Render(text),
+		s.ErrorHeaderText.Render(text),
 		lipgloss.WithWhitespaceChars("/"),
 		lipgloss.WithWhitespaceForeground(red),
 	)
 }
 
-func (m *Model) downloadBottle() tea.Cmd {
+// bottleView describes the hovered bottle for the status panel.
+func (m Model) bottleView() string {
+	tag, ok := m.field.Hovered()
+	if !ok {
+		return ""
+	}
+	bottle, err := m.formula.BottleFor(tag)
+	if err != nil {
+		return ""
+	}
+	s := m.styles
+	out := "\n\n" + s.StatusHeader.Render("Bottle") + "\n" +
+		"  Tag: " + bottle.Tag + "\n" +
+		"  Cellar: " + cellarType(bottle.Cellar) + "\n" +
+		fmt.Sprintf("  Rebuild: %d\n", m.formula.Bottle.Stable.Rebuild)
+	mf := m.index.Manifest(tag)
+	if mf == nil {
+		return out
+	}
+	size := "  Size: " + bottleSize(mf.BottleSize())
+	if installed, err := strconv.ParseUint(mf.Annotations.ShBrewBottleInstalledSize, 10, 64); err == nil {
+		size += " (" + humanize.Bytes(installed) + " installed)"
+	}
+	out += size + "\n"
+	if v := mf.Annotations.ShBrewBottleGlibcVersion; v != "" {
+		out += "  Requires glibc: >= " + v + "\n"
+	}
+	if v := mf.Annotations.ShBrewBottleCPUVariant; v != "" {
+		out += "  CPU: " + v + "\n"
+	}
+	return out
+}
+
+var (
+	depsKey = key.NewBinding(key.WithKeys("shift+up", "shift+down"), key.WithHelp("⇧↑/⇧↓", "deps"))
+	foldKey = key.NewBinding(key.WithKeys("left", "right"), key.WithHelp("←/→", "fold"))
+)
+
+// treeRows are the visible lines of the hovered bottle's dependency tree.
+func (m Model) treeRows() []treeRow {
+	tag, ok := m.field.Hovered()
+	if !ok || m.plans[tag] == nil || m.plans[tag].plan == nil {
+		return nil
+	}
+	return treeRows(m.plans[tag].plan.Root, "  ", "", func(p string) bool { return m.expanded[p] })
+}
+
+// treeKey moves the dependency tree cursor, or collapses or expands the node
+// under it. Collapsing a node that is already closed moves to its parent.
+func (m *Model) treeKey(k string) {
+	rows := m.treeRows()
+	if len(rows) == 0 {
+		return
+	}
+	m.node = min(m.node, len(rows)-1)
+	r := rows[m.node]
+	switch k {
+	case "shift+up":
+		m.node = max(0, m.node-1)
+	case "shift+down":
+		m.node = min(len(rows)-1, m.node+1)
+	case "right":
+		if r.collapsed {
+			m.expanded[r.path] = true
+		}
+	case "left":
+		if m.expanded[r.path] {
+			delete(m.expanded, r.path)
+			return
+		}
+		if i := slices.IndexFunc(rows, func(p treeRow) bool { return p.path == path.Dir(r.path) }); i >= 0 {
+			m.node = i
+		}
+	}
+}
+
+// planDependencies resolves the dependency plan of the hovered bottle once.
+func (m Model) planDependencies() tea.Cmd {
+	tag, ok := m.field.Hovered()
+	if !ok {
+		return nil
+	}
+	if _, ok := m.plans[tag]; ok {
+		return nil
+	}
+	m.plans[tag] = nil
+	ctx, formula, prefix := m.ctx, m.formula, m.prefix
 	return func() tea.Msg {
-		req, err := http.NewRequest("GET", m.selectedURL, nil)
-		if err != nil {
-			return m.appErrorBoundaryView(err.Error())
+		plan, err := planDependencies(ctx, formula, tag)
+		if err == nil {
+			plan.annotate(ctx, prefix)
 		}
-		req.Header.Add("Authorization", "Bearer QQ==")
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return m.appErrorBoundaryView(err.Error())
+		return depPlanMsg{tag: tag, plan: plan, err: err}
+	}
+}
+
+// depsView renders the dependency tree of the hovered bottle with the nodes
+// in m.expanded open, or "" until it is resolved.
+func (m Model) depsView() string {
+	tag, ok := m.field.Hovered()
+	if !ok {
+		return ""
+	}
+	msg := m.plans[tag]
+	if msg == nil {
+		return ""
+	}
+	s := m.styles
+	if msg.err != nil {
+		return "\n\n" + s.StatusHeader.Render("Dependencies") + "\n  " + s.ErrorHeaderText.UnsetPadding().Render(msg.err.Error()) + "\n"
+	}
+	plan := msg.plan
+	if len(plan.Root.Deps) == 0 {
+		return ""
+	}
+
+	label := func(r treeRow, cursor bool) string {
+		n, name := r.node, r.node.Formula.Name
+		if cursor {
+			name = s.Highlight.Render(name)
 		}
-		defer resp.Body.Close()
+		switch {
+		case r.collapsed:
+			name += " ▸"
+		case len(n.Deps) > 0:
+			name += " ▾"
+		}
+		switch {
+		case n.Bottle == nil:
+			return name + " " + s.ErrorHeaderText.UnsetPadding().Render("✗ no bottle")
+		case n.Installed:
+			return name + " " + s.StatusHeader.UnsetBold().Render("✓ installed")
+		case n.Cached:
+			return name + " " + s.StatusHeader.UnsetBold().Render("✓ cached")
+		case n.Size > 0:
+			return name + " " + s.Help.Render(humanize.Bytes(uint64(n.Size)))
+		}
+		return name
+	}
+	var b strings.Builder
+	rows := m.treeRows()
+	cursor := min(m.node, len(rows)-1)
+	for i, r := range rows {
+		b.WriteString(r.prefix + label(r, i == cursor) + "\n")
+	}
+	size, count := plan.downloadSize()
+	total := fmt.Sprintf("  %d to download", count)
+	if size > 0 {
+		total += ", " + humanize.Bytes(uint64(size))
+	}
+	total = s.Help.Render(total)
+	return "\n\n" + s.StatusHeader.Render("Dependencies") + "\n" + b.String() + total + "\n"
+}
 
-		f, err := os.Create(m.formula.Name + ".tar.gz")
-		if err != nil {
-			return m.appErrorBoundaryView(err.Error())
+// statusView renders the formula info panel shown to the right of form.
+// deps replaces the list of direct dependencies when set; extra is appended
+// below them.
+func statusView(s *Styles, width int, form string, formula *Formula, deps, extra string) string {
+	if deps == "" && len(formula.Dependencies) > 0 {
+		deps = "\n\n" + s.StatusHeader.Render("Dependencies") + "\n"
+		for _, dep := range formula.Dependencies {
+			deps += "  • " + dep + "\n"
 		}
-		defer f.Close()
-
-		m.pw = &progressWriter{
-			total:  int(resp.ContentLength),
-			file:   f,
-			reader: resp.Body,
-			onProgress: func(ratio float64) {
-				p.Send(progressMsg(ratio))
-			},
+	}
+	if extra != "" {
+		deps = strings.TrimSuffix(deps, "\n")
+	}
+	if notice := formula.deprecationNotice(); notice != "" {
+		deps = "\n\n" + s.ErrorHeaderText.UnsetPadding().Render("⚠ "+notice) + deps
+	}
+	// a terminal narrower than the form leaves no room for the status
+	statusWidth := max(0, min(60, width-lipgloss.Width(form)-s.Status.GetHorizontalFrameSize()))
+	statusMarginLeft := max(0, width-statusWidth-lipgloss.Width(form)-s.Status.GetMarginRight())
+	return s.Status.
+		Height(lipgloss.Height(form)).
+		Width(statusWidth).
+		MarginLeft(statusMarginLeft).
+		Render(s.StatusHeader.Render(formula.Name)+"\n"+
+			"Version: "+formula.Versions.Stable+"\n"+
+			"Homepage: "+formula.Homepage+"\n"+
+			"Description: "+formula.Desc,
+			deps,
+			extra,
+		)
+}
+
+// wait cancels any in-flight download and blocks until it has cleaned up.
+func (m Model) wait() {
+	m.cancel()
+	if m.started {
+		select {
+		case <-m.finished:
+		case <-time.After(5 * time.Second):
 		}
+	}
+}
 
-		// Start the download
-		m.pw.Start()
+func (m Model) downloadBottle() tea.Cmd {
+	ctx := m.ctx
+	formula := m.formula
+	tag := m.selectedTag
+	cached := m.cached
+	finished := m.finished
+	return func() tea.Msg {
+		defer close(finished)
+		bottle, err := formula.BottleFor(tag)
+		if err != nil {
+			return downloadErrMsg{err}
+		}
+		if err := enforceLicenses(ctx, formula, bottle.Tag); err != nil {
+			return downloadErrMsg{err}
+		}
+		dst, err := downloadPath(formula, bottle, cached)
+		if err != nil {
+			return downloadErrMsg{err}
+		}
+		if res := cachedBottle(formula, bottle, dst); res != nil {
+			return downloadDoneMsg{res}
+		}
+		start := time.Now()
+		resp, err := openBottle(ctx, bottle)
+		if err != nil {
+			return downloadErrMsg{err}
+		}
+		defer resp.Body.Close()
 
-		m.created = true
-		m.state = stateDone
+		p.Send(downloadStartedMsg{total: resp.ContentLength})
 
-		return progressMsg(100.0)
+		res, err := saveBottle(formula, bottle, resp, dst, start, func(ratio float64) {
+			p.Send(progressMsg(ratio))
+		})
+		if err != nil {
+			return downloadErrMsg{err}
+		}
+		return downloadDoneMsg{res}
 	}
 }
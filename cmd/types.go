@@ -1,15 +1,17 @@
 package cmd
 
+import "encoding/json"
+
 type Formula struct {
-	Name              string        `json:"name"`
-	FullName          string        `json:"full_name"`
-	Tap               string        `json:"tap"`
-	Oldnames          []interface{} `json:"oldnames"`
-	Aliases           []interface{} `json:"aliases"`
-	VersionedFormulae []interface{} `json:"versioned_formulae"`
-	Desc              string        `json:"desc"`
-	License           string        `json:"license"`
-	Homepage          string        `json:"homepage"`
+	Name              string   `json:"name"`
+	FullName          string   `json:"full_name"`
+	Tap               string   `json:"tap"`
+	Oldnames          []string `json:"oldnames"`
+	Aliases           []string `json:"aliases"`
+	VersionedFormulae []string `json:"versioned_formulae"`
+	Desc              string   `json:"desc"`
+	License           string   `json:"license"`
+	Homepage          string   `json:"homepage"`
 	Versions          struct {
 		Stable string `json:"stable"`
 		Head   string `json:"head"`
@@ -17,16 +19,16 @@ type Formula struct {
 	} `json:"versions"`
 	Urls struct {
 		Stable struct {
-			URL      string      `json:"url"`
-			Tag      interface{} `json:"tag"`
-			Revision interface{} `json:"revision"`
-			Using    interface{} `json:"using"`
-			Checksum string      `json:"checksum"`
+			URL      string `json:"url"`
+			Tag      string `json:"tag"`
+			Revision string `json:"revision"`
+			Using    string `json:"using"`
+			Checksum string `json:"checksum"`
 		} `json:"stable"`
 		Head struct {
-			URL    string      `json:"url"`
-			Branch string      `json:"branch"`
-			Using  interface{} `json:"using"`
+			URL    string `json:"url"`
+			Branch string `json:"branch"`
+			Using  string `json:"using"`
 		} `json:"head"`
 	} `json:"urls"`
 	Revision      int `json:"revision"`
@@ -36,6 +38,16 @@ type Formula struct {
 			Rebuild int    `json:"rebuild"`
 			RootURL string `json:"root_url"`
 			Files   struct {
+				Arm64Tahoe struct {
+					Cellar string `json:"cellar"`
+					URL    string `json:"url"`
+					Sha256 string `json:"sha256"`
+				} `json:"arm64_tahoe"`
+				Arm64Sequoia struct {
+					Cellar string `json:"cellar"`
+					URL    string `json:"url"`
+					Sha256 string `json:"sha256"`
+				} `json:"arm64_sequoia"`
 				Arm64Sonoma struct {
 					Cellar string `json:"cellar"`
 					URL    string `json:"url"`
@@ -51,6 +63,16 @@ type Formula struct {
 					URL    string `json:"url"`
 					Sha256 string `json:"sha256"`
 				} `json:"arm64_monterey"`
+				Tahoe struct {
+					Cellar string `json:"cellar"`
+					URL    string `json:"url"`
+					Sha256 string `json:"sha256"`
+				} `json:"tahoe"`
+				Sequoia struct {
+					Cellar string `json:"cellar"`
+					URL    string `json:"url"`
+					Sha256 string `json:"sha256"`
+				} `json:"sequoia"`
 				Sonoma struct {
 					Cellar string `json:"cellar"`
 					URL    string `json:"url"`
@@ -79,42 +101,41 @@ type Formula struct {
 			} `json:"files"`
 		} `json:"stable"`
 	} `json:"bottle"`
-	PourBottleOnlyIf        interface{}   `json:"pour_bottle_only_if"`
-	KegOnly                 bool          `json:"keg_only"`
-	KegOnlyReason           interface{}   `json:"keg_only_reason"`
-	Options                 []interface{} `json:"options"`
-	BuildDependencies       []string      `json:"build_dependencies"`
-	Dependencies            []string      `json:"dependencies"`
-	TestDependencies        []interface{} `json:"test_dependencies"`
-	RecommendedDependencies []interface{} `json:"recommended_dependencies"`
-	OptionalDependencies    []interface{} `json:"optional_dependencies"`
-	UsesFromMacos           []interface{} `json:"uses_from_macos"`
-	UsesFromMacosBounds     []interface{} `json:"uses_from_macos_bounds"`
-	Requirements            []interface{} `json:"requirements"`
-	ConflictsWith           []interface{} `json:"conflicts_with"`
-	ConflictsWithReasons    []interface{} `json:"conflicts_with_reasons"`
-	LinkOverwrite           []interface{} `json:"link_overwrite"`
-	Caveats                 interface{}   `json:"caveats"`
-	Installed               []interface{} `json:"installed"`
-	LinkedKeg               interface{}   `json:"linked_keg"`
-	Pinned                  bool          `json:"pinned"`
-	Outdated                bool          `json:"outdated"`
-	Deprecated              bool          `json:"deprecated"`
-	DeprecationDate         interface{}   `json:"deprecation_date"`
-	DeprecationReason       interface{}   `json:"deprecation_reason"`
-	Disabled                bool          `json:"disabled"`
-	DisableDate             interface{}   `json:"disable_date"`
-	DisableReason           interface{}   `json:"disable_reason"`
-	PostInstallDefined      bool          `json:"post_install_defined"`
-	Service                 interface{}   `json:"service"`
-	TapGitHead              string        `json:"tap_git_head"`
-	RubySourcePath          string        `json:"ruby_source_path"`
+	PourBottleOnlyIf        string             `json:"pour_bottle_only_if"`
+	KegOnly                 bool               `json:"keg_only"`
+	KegOnlyReason           *KegOnlyReason     `json:"keg_only_reason"`
+	Options                 []FormulaOption    `json:"options"`
+	BuildDependencies       []string           `json:"build_dependencies"`
+	Dependencies            []string           `json:"dependencies"`
+	TestDependencies        []string           `json:"test_dependencies"`
+	RecommendedDependencies []string           `json:"recommended_dependencies"`
+	OptionalDependencies    []string           `json:"optional_dependencies"`
+	UsesFromMacos           []MacOSDependency  `json:"uses_from_macos"`
+	UsesFromMacosBounds     []MacOSBound       `json:"uses_from_macos_bounds"`
+	Requirements            []Requirement      `json:"requirements"`
+	ConflictsWith           []string           `json:"conflicts_with"`
+	ConflictsWithReasons    []string           `json:"conflicts_with_reasons"`
+	LinkOverwrite           []string           `json:"link_overwrite"`
+	Caveats                 string             `json:"caveats"`
+	Installed               []InstalledVersion `json:"installed"`
+	LinkedKeg               string             `json:"linked_keg"`
+	Pinned                  bool               `json:"pinned"`
+	Outdated                bool               `json:"outdated"`
+	Deprecated              bool               `json:"deprecated"`
+	DeprecationDate         string             `json:"deprecation_date"`
+	DeprecationReason       string             `json:"deprecation_reason"`
+	Disabled                bool               `json:"disabled"`
+	DisableDate             string             `json:"disable_date"`
+	DisableReason           string             `json:"disable_reason"`
+	PostInstallDefined      bool               `json:"post_install_defined"`
+	Service                 *Service           `json:"service"`
+	TapGitHead              string             `json:"tap_git_head"`
+	RubySourcePath          string             `json:"ruby_source_path"`
 	RubySourceChecksum      struct {
 		Sha256 string `json:"sha256"`
 	} `json:"ruby_source_checksum"`
-	Variations struct {
-	} `json:"variations"`
-	Analytics struct {
+	Variations map[string]FormulaVariation `json:"variations"`
+	Analytics  struct {
 		Install          map[string]any `json:"install"`
 		InstallOnRequest map[string]any `json:"install_on_request"`
 		BuildError       map[string]any `json:"build_error"`
@@ -122,26 +143,169 @@ type Formula struct {
 	GeneratedDate string `json:"generated_date"`
 }
 
+// FormulaVariation overrides the formula's dependencies for one bottle tag.
+type FormulaVariation struct {
+	Dependencies        []string          `json:"dependencies"`
+	BuildDependencies   []string          `json:"build_dependencies"`
+	UsesFromMacos       []MacOSDependency `json:"uses_from_macos"`
+	UsesFromMacosBounds []MacOSBound      `json:"uses_from_macos_bounds"`
+}
+
+// FormulaOption is a build option such as --with-foo.
+type FormulaOption struct {
+	Option      string `json:"option"`
+	Description string `json:"description"`
+}
+
+// KegOnlyReason explains why a formula isn't linked into the prefix. Reason
+// is a symbol such as ":provided_by_macos" or free text.
+type KegOnlyReason struct {
+	Reason      string `json:"reason"`
+	Explanation string `json:"explanation"`
+}
+
+// MacOSDependency is a uses_from_macos entry. The API encodes it either as a
+// bare name or as {"name": "build"} / {"name": ["build", "test"]}; Contexts
+// is empty for runtime dependencies.
+type MacOSDependency struct {
+	Name     string
+	Contexts []string
+}
+
+func (d *MacOSDependency) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*d = MacOSDependency{Name: name}
+		return nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	*d = MacOSDependency{}
+	for name, raw := range m {
+		d.Name = name
+		var context string
+		if err := json.Unmarshal(raw, &context); err == nil {
+			d.Contexts = []string{context}
+		} else if err := json.Unmarshal(raw, &d.Contexts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d MacOSDependency) MarshalJSON() ([]byte, error) {
+	switch len(d.Contexts) {
+	case 0:
+		return json.Marshal(d.Name)
+	case 1:
+		return json.Marshal(map[string]string{d.Name: d.Contexts[0]})
+	}
+	return json.Marshal(map[string][]string{d.Name: d.Contexts})
+}
+
+// MacOSBound is the uses_from_macos_bounds entry at the same index as its
+// MacOSDependency. Since is the first macOS release providing the
+// dependency, e.g. "catalina"; empty means every release does.
+type MacOSBound struct {
+	Since string `json:"since,omitempty"`
+}
+
+// Requirement is a non-formula requirement such as :macos or :xcode.
+type Requirement struct {
+	Name     string   `json:"name"`
+	Cask     string   `json:"cask"`
+	Download string   `json:"download"`
+	Version  string   `json:"version"`
+	Contexts []string `json:"contexts"`
+	Specs    []string `json:"specs"`
+}
+
+// InstalledVersion is an installed keg as reported by `brew info --json`.
+type InstalledVersion struct {
+	Version               string              `json:"version"`
+	UsedOptions           []string            `json:"used_options"`
+	BuiltAsBottle         bool                `json:"built_as_bottle"`
+	PouredFromBottle      bool                `json:"poured_from_bottle"`
+	Time                  int64               `json:"time"`
+	RuntimeDependencies   []RuntimeDependency `json:"runtime_dependencies"`
+	InstalledAsDependency bool                `json:"installed_as_dependency"`
+	InstalledOnRequest    bool                `json:"installed_on_request"`
+}
+
+// Service is the formula's background service definition.
+type Service struct {
+	Run                  ServiceCommand    `json:"run"`
+	RunType              string            `json:"run_type"`
+	Interval             int               `json:"interval"`
+	Cron                 string            `json:"cron"`
+	KeepAlive            *ServiceKeepAlive `json:"keep_alive"`
+	LaunchOnlyOnce       bool              `json:"launch_only_once"`
+	RequireRoot          bool              `json:"require_root"`
+	EnvironmentVariables map[string]string `json:"environment_variables"`
+	WorkingDir           string            `json:"working_dir"`
+	RootDir              string            `json:"root_dir"`
+	InputPath            string            `json:"input_path"`
+	LogPath              string            `json:"log_path"`
+	ErrorLogPath         string            `json:"error_log_path"`
+	ProcessType          string            `json:"process_type"`
+	NiceLevel            int               `json:"nice"`
+	RestartDelay         int               `json:"restart_delay"`
+	MacOSLegacyTimers    bool              `json:"macos_legacy_timers"`
+	Name                 map[string]string `json:"name"`
+}
+
+// ServiceKeepAlive says when the service manager restarts the service.
+type ServiceKeepAlive struct {
+	Always         bool   `json:"always"`
+	SuccessfulExit bool   `json:"successful_exit"`
+	Crashed        bool   `json:"crashed"`
+	Path           string `json:"path"`
+}
+
+// ServiceCommand is a service's command line. The API encodes it as a string,
+// an argv array, or per-OS argv arrays keyed by "macos" and "linux".
+type ServiceCommand struct {
+	Args []string
+	ByOS map[string][]string
+}
+
+func (c *ServiceCommand) UnmarshalJSON(data []byte) error {
+	*c = ServiceCommand{}
+	if string(data) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		c.Args = []string{s}
+		return nil
+	}
+	if err := json.Unmarshal(data, &c.Args); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &c.ByOS)
+}
+
+func (c ServiceCommand) MarshalJSON() ([]byte, error) {
+	if c.ByOS != nil {
+		return json.Marshal(c.ByOS)
+	}
+	return json.Marshal(c.Args)
+}
+
+// For returns the command line on os ("macos" or "linux").
+func (c ServiceCommand) For(os string) []string {
+	if c.ByOS != nil {
+		return c.ByOS[os]
+	}
+	return c.Args
+}
+
 type Bottle struct {
-	SchemaVersion int `json:"schemaVersion"`
-	Manifests     []struct {
-		MediaType string `json:"mediaType"`
-		Digest    string `json:"digest"`
-		Size      int    `json:"size"`
-		Platform  struct {
-			Architecture string `json:"architecture"`
-			Os           string `json:"os"`
-			OsVersion    string `json:"os.version"`
-		} `json:"platform"`
-		Annotations struct {
-			OrgOpencontainersImageRefName string `json:"org.opencontainers.image.ref.name"`
-			ShBrewBottleCPUVariant        string `json:"sh.brew.bottle.cpu.variant"`
-			ShBrewBottleDigest            string `json:"sh.brew.bottle.digest"`
-			ShBrewBottleGlibcVersion      string `json:"sh.brew.bottle.glibc.version"`
-			ShBrewTab                     string `json:"sh.brew.tab"`
-		} `json:"annotations"`
-	} `json:"manifests"`
-	Annotations struct {
+	SchemaVersion int              `json:"schemaVersion"`
+	Manifests     []BottleManifest `json:"manifests"`
+	Annotations   struct {
 		ComGithubPackageType                string `json:"com.github.package.type"`
 		OrgOpencontainersImageCreated       string `json:"org.opencontainers.image.created"`
 		OrgOpencontainersImageDescription   string `json:"org.opencontainers.image.description"`
@@ -156,3 +320,23 @@ type Bottle struct {
 		OrgOpencontainersImageVersion       string `json:"org.opencontainers.image.version"`
 	} `json:"annotations"`
 }
+
+type BottleManifest struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int    `json:"size"`
+	Platform  struct {
+		Architecture string `json:"architecture"`
+		Os           string `json:"os"`
+		OsVersion    string `json:"os.version"`
+	} `json:"platform"`
+	Annotations struct {
+		OrgOpencontainersImageRefName string `json:"org.opencontainers.image.ref.name"`
+		ShBrewBottleCPUVariant        string `json:"sh.brew.bottle.cpu.variant"`
+		ShBrewBottleDigest            string `json:"sh.brew.bottle.digest"`
+		ShBrewBottleGlibcVersion      string `json:"sh.brew.bottle.glibc.version"`
+		ShBrewBottleSize              string `json:"sh.brew.bottle.size"`
+		ShBrewBottleInstalledSize     string `json:"sh.brew.bottle.installed_size"`
+		ShBrewTab                     string `json:"sh.brew.tab"`
+	} `json:"annotations"`
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeFormula(t *testing.T) {
+	tests := []struct {
+		file  string
+		check func(t *testing.T, f *Formula)
+	}{
+		{"curl.json", func(t *testing.T, f *Formula) {
+			want := []MacOSDependency{
+				{Name: "krb5"},
+				{Name: "python", Contexts: []string{"build"}},
+				{Name: "perl", Contexts: []string{"build", "test"}},
+				{Name: "zlib"},
+			}
+			if len(f.UsesFromMacos) != len(want) {
+				t.Fatalf("uses_from_macos = %+v, want %+v", f.UsesFromMacos, want)
+			}
+			for i, d := range want {
+				got := f.UsesFromMacos[i]
+				if got.Name != d.Name || !slices.Equal(got.Contexts, d.Contexts) {
+					t.Errorf("uses_from_macos[%d] = %+v, want %+v", i, got, d)
+				}
+			}
+			if len(f.UsesFromMacosBounds) != 4 || f.UsesFromMacosBounds[3].Since != "catalina" {
+				t.Errorf("uses_from_macos_bounds = %+v", f.UsesFromMacosBounds)
+			}
+			if f.KegOnlyReason == nil || f.KegOnlyReason.Reason != ":provided_by_macos" {
+				t.Errorf("keg_only_reason = %+v", f.KegOnlyReason)
+			}
+			if f.Service != nil {
+				t.Errorf("service = %+v, want nil", f.Service)
+			}
+			if v, ok := f.Variations["x86_64_linux"]; !ok || !slices.Contains(v.Dependencies, "zlib") {
+				t.Errorf("variations = %+v", f.Variations)
+			}
+		}},
+		{"postgresql@17.json", func(t *testing.T, f *Formula) {
+			if f.Service == nil {
+				t.Fatal("service = nil")
+			}
+			run := f.Service.Run.For("linux")
+			if len(run) != 3 || run[0] != "$HOMEBREW_PREFIX/opt/postgresql@17/bin/postgres" {
+				t.Errorf("service.run = %v", run)
+			}
+			if !slices.Equal(f.Service.Run.For("macos"), run) {
+				t.Errorf("service.run differs per OS: %v", f.Service.Run.For("macos"))
+			}
+			if f.Service.KeepAlive == nil || !f.Service.KeepAlive.Always {
+				t.Errorf("service.keep_alive = %+v", f.Service.KeepAlive)
+			}
+			if f.Service.EnvironmentVariables["LC_ALL"] != "C" {
+				t.Errorf("service.environment_variables = %v", f.Service.EnvironmentVariables)
+			}
+			if f.KegOnlyReason == nil || f.KegOnlyReason.Reason != ":versioned_formula" {
+				t.Errorf("keg_only_reason = %+v", f.KegOnlyReason)
+			}
+		}},
+		{"per-os-service.json", func(t *testing.T, f *Formula) {
+			if f.Service == nil {
+				t.Fatal("service = nil")
+			}
+			if got := f.Service.Run.For("macos"); len(got) != 4 || slices.Contains(got, "-p") {
+				t.Errorf("service.run macos = %v", got)
+			}
+			if got := f.Service.Run.For("linux"); len(got) != 5 || got[2] != "-p" {
+				t.Errorf("service.run linux = %v", got)
+			}
+			if f.Service.KeepAlive == nil || !f.Service.KeepAlive.Crashed {
+				t.Errorf("service.keep_alive = %+v", f.Service.KeepAlive)
+			}
+			if !f.Service.RequireRoot || f.Service.Name["macos"] != "net.unbound" {
+				t.Errorf("service = %+v", f.Service)
+			}
+			if f.KegOnlyReason != nil {
+				t.Errorf("keg_only_reason = %+v, want nil", f.KegOnlyReason)
+			}
+		}},
+		{"requirements.json", func(t *testing.T, f *Formula) {
+			if len(f.Requirements) != 2 {
+				t.Fatalf("requirements = %+v", f.Requirements)
+			}
+			xcode := f.Requirements[0]
+			if xcode.Name != "xcode" || xcode.Version != "10.1" || xcode.Cask != "" || !slices.Equal(xcode.Contexts, []string{"build"}) {
+				t.Errorf("requirements[0] = %+v", xcode)
+			}
+			if macos := f.Requirements[1]; macos.Name != "macos" || macos.Version != "" || len(macos.Contexts) != 0 {
+				t.Errorf("requirements[1] = %+v", macos)
+			}
+			if len(f.UsesFromMacos) != 1 || f.UsesFromMacos[0].Name != "swift" || !slices.Equal(f.UsesFromMacos[0].Contexts, []string{"build"}) {
+				t.Errorf("uses_from_macos = %+v", f.UsesFromMacos)
+			}
+			if f.KegOnlyReason != nil || f.Deprecated {
+				t.Errorf("keg_only_reason = %+v, deprecated = %v", f.KegOnlyReason, f.Deprecated)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			var f Formula
+			if err := json.Unmarshal(readFixture(t, tt.file), &f); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, &f)
+
+			// what we print as JSON must decode to the same thing
+			data, err := json.Marshal(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var again Formula
+			if err := json.Unmarshal(data, &again); err != nil {
+				t.Fatalf("failed to decode marshalled formula: %v", err)
+			}
+			tt.check(t, &again)
+		})
+	}
+}
+
+func TestDecodeFormulaIndex(t *testing.T) {
+	formulae, err := decodeFormulaIndex(readFixture(t, "formula.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range formulae {
+		names = append(names, f.Name)
+	}
+	// "broken" has a string revision and is skipped on its own
+	want := []string{"curl", "postgresql@17", "unbound", "swiftformat"}
+	if !slices.Equal(names, want) {
+		t.Errorf("decoded %v, want %v", names, want)
+	}
+
+	if _, err := decodeFormulaIndex([]byte(`{"name": "curl"}`)); err == nil {
+		t.Error("decoded an index that isn't an array")
+	}
+}
+
+func TestBottleTags(t *testing.T) {
+	var f Formula
+	data := `{"name": "tool", "bottle": {"stable": {"files": {
+		"arm64_tahoe": {"cellar": ":any", "url": "https://example.invalid/a", "sha256": "a"},
+		"sequoia": {"cellar": ":any", "url": "https://example.invalid/b", "sha256": "b"},
+		"x86_64_linux": {"cellar": ":any", "url": "https://example.invalid/c", "sha256": "c"}
+	}}}}`
+	if err := json.Unmarshal([]byte(data), &f); err != nil {
+		t.Fatal(err)
+	}
+	var tags []string
+	for _, b := range f.Bottles() {
+		tags = append(tags, b.Tag)
+	}
+	if want := []string{"arm64_tahoe", "sequoia", "x86_64_linux"}; !slices.Equal(tags, want) {
+		t.Errorf("bottles %v, want %v", tags, want)
+	}
+	if b, err := f.BottleFor("sequoia"); err != nil || b.Sha256 != "b" {
+		t.Errorf("BottleFor(sequoia) = %+v, %v", b, err)
+	}
+	for tag, want := range map[string]bool{"arm64_sequoia": true, "tahoe": true, "arm64_big_sur": false, "sequoia_linux": false} {
+		if got := knownBottleTag(tag); got != want {
+			t.Errorf("knownBottleTag(%s) = %v, want %v", tag, got, want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// removeLinks deletes the links (relative to prefix) that still point into
+// keg and prunes directories left empty. Links that were replaced by
+// something else are left alone.
+func removeLinks(keg *Keg, links []string) error {
+	for _, link := range links {
+		path := filepath.Join(keg.Prefix, link)
+		target, err := os.Readlink(path)
+		if err != nil {
+			continue // gone or no longer a link
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		if !within(target, keg.Path()) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove link: %w", err)
+		}
+		pruneDirs(filepath.Dir(path), keg.Prefix)
+	}
+	return nil
+}
+
+// removeKeg deletes the files bottle-bomb poured for rec and then the keg
+// directory if nothing else was put there.
+func removeKeg(prefix string, rec *installRecord) error {
+	keg := rec.keg(prefix)
+	for _, file := range rec.Files {
+		path := filepath.Join(prefix, file)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		pruneDirs(filepath.Dir(path), filepath.Dir(keg.Path()))
+	}
+	if entries, err := os.ReadDir(keg.Path()); err == nil && len(entries) > 0 {
+		logger.Warn("Keg contains files bottle-bomb didn't create, leaving them", "keg", keg.Path())
+	}
+	pruneDirs(filepath.Dir(keg.Path()), filepath.Join(prefix, "Cellar"))
+	return nil
+}
+
+// pruneDirs removes dir and its parents while they are empty, stopping at stop.
+func pruneDirs(dir, stop string) {
+	for within(dir, stop) && filepath.Clean(dir) != filepath.Clean(stop) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// within reports whether path is dir or inside it.
+func within(path, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// uninstallCmd represents the uninstall command
+var uninstallCmd = &cobra.Command{
+	Use:           "uninstall <formula>...",
+	Aliases:       []string{"rm", "remove"},
+	Short:         "Remove kegs installed by bottle-bomb",
+	Args:          cobra.MinimumNArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prefix, err := prefixFlag(cmd)
+		if err != nil {
+			return err
+		}
+		ignoreDeps, _ := cmd.Flags().GetBool("ignore-dependencies")
+
+		db, err := openInstallDB(prefix)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range args {
+			recs := db.get(name)
+			if len(recs) == 0 {
+				return fmt.Errorf("'%s' was not installed by bottle-bomb in %s", name, prefix)
+			}
+			if !ignoreDeps {
+				var dependents []string
+				for _, dep := range db.dependents(name) {
+					if !slices.Contains(args, dep) {
+						dependents = append(dependents, dep)
+					}
+				}
+				if len(dependents) > 0 {
+					return fmt.Errorf("refusing to uninstall '%s' because it is required by %s (use --ignore-dependencies)",
+						name, strings.Join(dependents, ", "))
+				}
+			}
+			for _, rec := range recs {
+				if err := removeLinks(rec.keg(prefix), rec.Links); err != nil {
+					return err
+				}
+				if err := removeKeg(prefix, rec); err != nil {
+					return err
+				}
+				db.remove(rec)
+				if err := db.save(); err != nil {
+					return err
+				}
+				logger.Info("Uninstalled", "keg", rec.keg(prefix).Path(), "files", len(rec.Files))
+			}
+		}
+		return nil
+	},
+}
+
+// unlinkCmd represents the unlink command
+var unlinkCmd = &cobra.Command{
+	Use:           "unlink <formula>...",
+	Short:         "Remove a keg's symlinks from the prefix",
+	Args:          cobra.MinimumNArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prefix, err := prefixFlag(cmd)
+		if err != nil {
+			return err
+		}
+
+		db, err := openInstallDB(prefix)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range args {
+			recs := db.get(name)
+			if len(recs) == 0 {
+				return fmt.Errorf("'%s' was not installed by bottle-bomb in %s", name, prefix)
+			}
+			for _, rec := range recs {
+				keg := rec.keg(prefix)
+				// keep opt/<name> so dependents still resolve, like `brew unlink`
+				opt, _ := filepath.Rel(prefix, keg.OptPath())
+				var unlink, keep []string
+				for _, link := range rec.Links {
+					if link == opt {
+						keep = append(keep, link)
+					} else {
+						unlink = append(unlink, link)
+					}
+				}
+				if err := removeLinks(keg, unlink); err != nil {
+					return err
+				}
+				rec.Links = keep
+				logger.Info("Unlinked", "keg", keg.Path(), "links", len(unlink))
+			}
+			if err := db.save(); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(uninstallCmd)
+	rootCmd.AddCommand(unlinkCmd)
+	uninstallCmd.Flags().Bool("ignore-dependencies", false, "Uninstall even if other installed formulae depend on it")
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// upgradeKeg pours the current bottle of an outdated formula next to the
+// installed keg and moves the links over. The new keg's links replace the
+// old ones in place before any old link is removed, and if any step fails the
+// new keg is removed and the old links are restored.
+func upgradeKeg(ctx context.Context, db *installDB, o outdatedKeg, cleanup bool, opts linkOptions) (err error) {
+	prefix := db.prefix
+	formula := o.formula
+	oldKeg := &Keg{Prefix: prefix, Name: o.Name, Version: o.InstalledVersion}
+
+	var oldRec *installRecord
+	for _, rec := range db.get(o.Name) {
+		if rec.Version == oldKeg.Version {
+			oldRec = rec
+		}
+	}
+	onRequest := oldRec == nil || oldRec.InstalledOnRequest
+
+	bottle, err := formula.BottleFor(o.Tag)
+	if err != nil {
+		return err
+	}
+	index, err := getBottleIndex(ctx, formula)
+	if err != nil {
+		logger.Warn("Failed to get bottle metadata", "err", err)
+	}
+	res, err := downloadNonInteractive(ctx, formula, bottle.Tag, true, false)
+	if err != nil {
+		return err
+	}
+
+	// a new rebuild of the same version replaces the keg, so move it aside
+	var aside string
+	if oldKeg.Version == formula.pkgVersion() {
+		aside = filepath.Join(filepath.Dir(oldKeg.Path()), ".upgrade-"+oldKeg.Version)
+		if err := os.Rename(oldKeg.Path(), aside); err != nil {
+			return fmt.Errorf("failed to move old keg aside: %w", err)
+		}
+	}
+
+	var newKeg *Keg
+	var poured bool
+	var links []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		if newKeg != nil {
+			if rerr := removeLinks(newKeg, db.rel(links)); rerr != nil {
+				logger.Error("Failed to remove new links", "err", rerr)
+			}
+			if poured {
+				os.RemoveAll(newKeg.Path())
+			}
+		}
+		if aside != "" {
+			if rerr := os.Rename(aside, oldKeg.Path()); rerr != nil {
+				logger.Error("Failed to move old keg back", "path", aside, "err", rerr)
+			}
+		}
+		if oldRec != nil {
+			restoreLinks(oldKeg, oldRec.Links)
+		}
+		err = fmt.Errorf("%w (rolled back to %s)", err, oldKeg.Path())
+	}()
+
+	if newKeg, poured, err = pourBottle(res.Path, prefix, formula, bottle); err != nil {
+		return fmt.Errorf("failed to pour bottle: %w", err)
+	}
+	if poured {
+		if err := writeReceipt(newKeg, formula, index.Manifest(bottle.Tag), onRequest); err != nil {
+			return err
+		}
+	}
+
+	// links into the old keg are replaced atomically by linkKeg, so the
+	// prefix never lacks a command both versions provide
+	links, err = linkKeg(newKeg, formula, opts)
+	if err != nil {
+		return fmt.Errorf("failed to link %s: %w", newKeg.Path(), err)
+	}
+	rec := &installRecord{
+		Name:               newKeg.Name,
+		Version:            newKeg.Version,
+		Tag:                bottle.Tag,
+		Rebuild:            formula.Bottle.Stable.Rebuild,
+		Links:              db.rel(links),
+		Dependencies:       formula.Dependencies,
+		InstalledOnRequest: onRequest,
+		InstalledAt:        time.Now(),
+	}
+	if poured {
+		files, err := kegFiles(newKeg)
+		if err != nil {
+			return err
+		}
+		rec.Files = db.rel(files)
+	}
+	if oldRec != nil {
+		var stale []string
+		for _, link := range oldRec.Links {
+			if !slices.Contains(rec.Links, link) {
+				stale = append(stale, link)
+			}
+		}
+		if err := removeLinks(oldKeg, stale); err != nil {
+			return err
+		}
+	}
+
+	db.put(rec)
+	switch {
+	case aside != "":
+	case cleanup && oldRec != nil:
+		db.remove(oldRec)
+	case cleanup:
+		logger.Warn("Not removing keg bottle-bomb didn't install", "keg", oldKeg.Path())
+	case oldRec != nil:
+		oldRec.Links = nil
+	}
+	if err := db.save(); err != nil {
+		return err
+	}
+
+	// the upgrade is recorded, so the previous keg can go
+	switch {
+	case aside != "":
+		if err := os.RemoveAll(aside); err != nil {
+			logger.Warn("Failed to remove previous rebuild", "path", aside, "err", err)
+		}
+	case cleanup && oldRec != nil:
+		if err := removeKeg(prefix, oldRec); err != nil {
+			logger.Warn("Failed to remove previous keg", "keg", oldKeg.Path(), "err", err)
+		}
+	}
+
+	logger.Info("Upgraded", "formula", o.Name, "from", oldKeg.Version, "to", newKeg.Version)
+	printCaveats(formula.caveats(prefix))
+	return nil
+}
+
+// restoreLinks recreates the recorded links of keg after a failed upgrade.
+func restoreLinks(keg *Keg, links []string) {
+	for _, link := range links {
+		src := filepath.Join(keg.Path(), link)
+		switch filepath.Join(keg.Prefix, link) {
+		case keg.OptPath(), filepath.Join(keg.Prefix, "var", "homebrew", "linked", keg.Name):
+			src = keg.Path()
+		}
+		if _, err := replaceSymlink(filepath.Join(keg.Prefix, link), src); err != nil {
+			logger.Error("Failed to restore link", "link", link, "err", err)
+		}
+	}
+}
+
+// upgradeCmd represents the upgrade command
+var upgradeCmd = &cobra.Command{
+	Use:           "upgrade [formula...]",
+	Short:         "Upgrade outdated kegs in the prefix",
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prefix, err := prefixFlag(cmd)
+		if err != nil {
+			return err
+		}
+		cleanup, _ := cmd.Flags().GetBool("cleanup")
+		force, _ := cmd.Flags().GetBool("force")
+		overwrite, _ := cmd.Flags().GetBool("overwrite")
+		allowDisabled, _ := cmd.Flags().GetBool("allow-disabled")
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+
+		outdated, err := findOutdated(ctx, prefix, args)
+		if err != nil {
+			return err
+		}
+		if len(outdated) == 0 {
+			logger.Info("Everything is up to date", "prefix", prefix)
+			return nil
+		}
+
+		db, err := openInstallDB(prefix)
+		if err != nil {
+			return err
+		}
+		for _, o := range outdated {
+			if err := o.formula.checkDisabled(); err != nil && !allowDisabled {
+				logger.Warn("Skipping", "formula", o.Name, "err", err)
+				continue
+			}
+			if err := upgradeKeg(ctx, db, o, cleanup, linkOptions{
+				Force:     force,
+				Overwrite: overwrite,
+				Globs:     o.formula.LinkOverwrite,
+			}); err != nil {
+				return fmt.Errorf("failed to upgrade '%s': %w", o.Name, err)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(upgradeCmd)
+	upgradeCmd.Flags().Bool("cleanup", false, "Remove the previous keg after upgrading")
+	upgradeCmd.Flags().Bool("force", false, "Link keg-only formulae")
+	upgradeCmd.Flags().Bool("overwrite", false, "Replace conflicting files in the prefix")
+	upgradeCmd.Flags().Bool("allow-disabled", false, "Upgrade formulae Homebrew has disabled")
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// splitPkgVersion splits a keg version like "1.7.1_1" into the version and
+// the formula revision.
+func splitPkgVersion(pkg string) (string, int) {
+	if i := strings.LastIndexByte(pkg, '_'); i > 0 {
+		if rev, err := strconv.Atoi(pkg[i+1:]); err == nil {
+			return pkg[:i], rev
+		}
+	}
+	return pkg, 0
+}
+
+// comparePkgVersions compares two keg versions including their revisions.
+func comparePkgVersions(a, b string) int {
+	av, ar := splitPkgVersion(a)
+	bv, br := splitPkgVersion(b)
+	if c := compareVersions(av, bv); c != 0 {
+		return c
+	}
+	switch {
+	case ar < br:
+		return -1
+	case ar > br:
+		return 1
+	}
+	return 0
+}
+
+// compareVersions compares two version strings roughly the way Homebrew's
+// Version class does: numeric runs compare as numbers, letter runs as
+// strings, and pre-release tokens (alpha, beta, rc, ...) sort before release.
+// A missing token counts as 0, so 1.0 and 1.0.0 are equal.
+func compareVersions(a, b string) int {
+	at, bt := versionTokens(a), versionTokens(b)
+	for i := 0; i < max(len(at), len(bt)); i++ {
+		var x, y string
+		if i < len(at) {
+			x = at[i]
+		}
+		if i < len(bt) {
+			y = bt[i]
+		}
+		if c := compareToken(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func versionTokens(v string) []string {
+	var tokens []string
+	var cur strings.Builder
+	var digit bool
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(v) {
+		switch {
+		case unicode.IsDigit(r):
+			if !digit {
+				flush()
+			}
+			digit = true
+			cur.WriteRune(r)
+		case unicode.IsLetter(r):
+			if digit {
+				flush()
+			}
+			digit = false
+			cur.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// compareToken compares two version tokens; "" is a missing one.
+func compareToken(a, b string) int {
+	switch {
+	case a == "":
+		return -compareMissing(b)
+	case b == "":
+		return compareMissing(a)
+	}
+	an, aerr := strconv.ParseUint(a, 10, 64)
+	bn, berr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aerr == nil && berr == nil:
+		switch {
+		case an < bn:
+			return -1
+		case an > bn:
+			return 1
+		}
+		return 0
+	case aerr == nil:
+		return 1
+	case berr == nil:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// compareMissing compares a token with a missing one, which is equal to 0,
+// newer than a pre-release and older than anything else.
+func compareMissing(token string) int {
+	switch n, err := strconv.ParseUint(token, 10, 64); {
+	case token == "", err == nil && n == 0:
+		return 0
+	case isPreRelease(token):
+		return -1
+	}
+	return 1
+}
+
+func isPreRelease(token string) bool {
+	switch token {
+	case "alpha", "beta", "pre", "rc", "dev", "preview":
+		return true
+	}
+	return false
+}
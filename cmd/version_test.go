@@ -0,0 +1,55 @@
+package cmd
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.0", 0},
+		{"1.0.0", "1.0", 0},
+		{"1", "1.0.0.0", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.0.1", "1.0", 1},
+		{"1.2", "1.10", -1},
+		{"2.39", "2.4", 1},
+		{"1.0", "1.0rc1", 1},
+		{"1.0.0", "1.0-rc1", 1},
+		{"1.0-beta", "1.0", -1},
+		{"1.0alpha", "1.0beta", -1},
+		{"1.0rc1", "1.0rc2", -1},
+		{"1.0", "1.0a", -1},
+		{"1.0a", "1.0b", -1},
+		{"1.1", "1.0z", 1},
+		{"1.0.1", "1.0a", 1},
+		{"2024.01.05", "2024.1.5", 0},
+		{"", "", 0},
+		{"2.34", "", 1},
+		{"", "2.34", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestComparePkgVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.7.1", "1.7.1", 0},
+		{"1.7.1_1", "1.7.1", 1},
+		{"1.7.1", "1.7.1_2", -1},
+		{"1.7_1", "1.7.0_1", 0},
+		{"1.7.1_1", "1.7.2", -1},
+	}
+	for _, tt := range tests {
+		if got := comparePkgVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("comparePkgVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}